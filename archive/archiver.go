@@ -8,10 +8,52 @@ import (
 	"github.com/jpg0/flickrup/processing"
 	"github.com/juju/errors"
 	"github.com/jpg0/flickrup/filetype"
+	"github.com/jpg0/flickrup/imagehash"
+	"github.com/jpg0/flickrup/index"
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
 )
 
-func Archive(ctx *processing.ProcessingContext) processing.ProcessingResult {
-	newPath, err := archiveFileByDate(ctx.File.Filepath(), ctx.Config.ArchiveDir, ctx.DateTakenForArchive(), ctx.ArchiveSubdir)
+type Archiver struct {
+	index *index.Index
+}
+
+//index may be nil, in which case archived files are not indexed
+func NewArchiver(idx *index.Index) *Archiver {
+	return &Archiver{
+		index: idx,
+	}
+}
+
+func (archiver *Archiver) Archive(ctx *processing.ProcessingContext) processing.ProcessingResult {
+	if ctx.Checksum == "" {
+		checksum, err := index.HashFile(ctx.File.Filepath())
+
+		if err != nil {
+			log.Warnf("Failed to hash %v: %v", ctx.File.Name(), err)
+		}
+
+		ctx.Checksum = checksum
+	}
+
+	if ctx.PerceptualHash == "" && imagehash.Supported(ctx.File.Filepath()) {
+		hash, err := imagehash.HashFile(ctx.File.Filepath())
+
+		if err != nil {
+			log.Warnf("Failed to compute perceptual hash of %v: %v", ctx.File.Name(), err)
+		} else {
+			ctx.PerceptualHash = imagehash.Format(hash)
+		}
+	}
+
+	var newPath string
+	var err error
+
+	if ctx.ReplacingPath != "" {
+		newPath, err = archiver.replaceArchived(ctx.Context, ctx.File.Filepath(), ctx.ReplacingPath)
+	} else {
+		newPath, err = archiveFileByDate(ctx.Context, ctx.File.Filepath(), ctx.Config.ArchiveDir, ctx.DateTakenForArchive(), ctx.ArchiveSubdir)
+	}
 
 	if err != nil {
 		return processing.NewErrorResult(errors.Trace(err))
@@ -19,10 +61,82 @@ func Archive(ctx *processing.ProcessingContext) processing.ProcessingResult {
 
 	ctx.ArchivedAs = newPath
 
+	if archiver.index != nil {
+		err = archiver.index.Put(indexItem(ctx))
+
+		if err != nil {
+			log.Warnf("Failed to index %v: %v", newPath, err)
+		}
+	}
+
 	return processing.NewSuccessResult()
 }
 
-func archiveFileByDate(file string, toDir string, date time.Time, subdir string) (string, error) {
+func indexItem(ctx *processing.ProcessingContext) *index.Item {
+	keywords := ctx.File.Keywords()
+
+	item := &index.Item{
+		PhotoID: ctx.UploadedId,
+		Path: ctx.ArchivedAs,
+		Tags: keywords.All().Slice(),
+		Sets: ctx.Sets(),
+		DateTaken: ctx.File.DateTaken(),
+		Visibility: ctx.Visibilty,
+		Checksum: ctx.Checksum,
+		PerceptualHash: ctx.PerceptualHash,
+	}
+
+	lat, latErr := ParseCoordinate(ctx.File.StringTag("GPSLatitude"))
+	long, longErr := ParseCoordinate(ctx.File.StringTag("GPSLongitude"))
+
+	if latErr == nil && longErr == nil {
+		item.HasGeo = true
+		item.Latitude = lat
+		item.Longitude = long
+	}
+
+	return item
+}
+
+//moves the file over the archived copy of the photo it replaced, keeping the new file's name
+func (archiver *Archiver) replaceArchived(ctx context.Context, file string, replacing string) (string, error) {
+	targetDir := filepath.Dir(replacing)
+	err := os.MkdirAll(targetDir, 0755)
+
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+
+	newName := filepath.Join(targetDir, filepath.Base(file))
+
+	err = filetype.MoveFileContext(ctx, file, newName)
+
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+
+	if newName != replacing {
+		log.Infof("Removing %v, which was replaced by %v", replacing, newName)
+
+		err = os.Remove(replacing)
+
+		if err != nil && !os.IsNotExist(err) {
+			log.Warnf("Failed to remove replaced file %v: %v", replacing, err)
+		}
+
+		if archiver.index != nil {
+			err = archiver.index.Remove(replacing)
+
+			if err != nil {
+				log.Warnf("Failed to remove %v from the index: %v", replacing, err)
+			}
+		}
+	}
+
+	return newName, nil
+}
+
+func archiveFileByDate(ctx context.Context, file string, toDir string, date time.Time, subdir string) (string, error) {
 	targetDir := fmt.Sprintf("%v/%v/%.2d/%v", toDir, date.Year(), date.Month(), subdir)
 	err := os.MkdirAll(targetDir, 0755)
 
@@ -32,11 +146,11 @@ func archiveFileByDate(file string, toDir string, date time.Time, subdir string)
 
 	newName := fmt.Sprintf("%v/%v", targetDir, filepath.Base(file))
 
-	err = filetype.MoveFile(file, newName)
+	err = filetype.MoveFileContext(ctx, file, newName)
 
 	if err != nil {
 		return "", errors.Trace(err)
 	}
 
 	return newName, nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,41 @@
+package archive
+
+import (
+	"github.com/juju/errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var dmsPattern = regexp.MustCompile(`^(\d+) deg (\d+)' ([\d.]+)" ?([NSEW])$`)
+
+//accepts decimal degrees or exiftool's default form, e.g. 51 deg 30' 26.00" N
+func ParseCoordinate(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+
+	if s == "" {
+		return 0, errors.New("No coordinate")
+	}
+
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f, nil
+	}
+
+	parts := dmsPattern.FindStringSubmatch(s)
+
+	if parts == nil {
+		return 0, errors.Errorf("Unrecognised coordinate: %v", s)
+	}
+
+	degrees, _ := strconv.ParseFloat(parts[1], 64)
+	minutes, _ := strconv.ParseFloat(parts[2], 64)
+	seconds, _ := strconv.ParseFloat(parts[3], 64)
+
+	rv := degrees + minutes/60 + seconds/3600
+
+	if parts[4] == "S" || parts[4] == "W" {
+		rv = -rv
+	}
+
+	return rv, nil
+}
@@ -0,0 +1,33 @@
+package archive
+
+import (
+	"math"
+	"testing"
+)
+
+func assertCoordinate(s string, expected float64, t *testing.T) {
+	actual, err := ParseCoordinate(s)
+
+	if err != nil {
+		t.Fatalf("Failed to parse %v: %v", s, err)
+	}
+
+	if math.Abs(actual-expected) > 0.0001 {
+		t.Errorf("Test failed, expected: '%v', got:  '%v'", expected, actual)
+	}
+}
+
+func TestDecimalCoordinate(t *testing.T) {
+	assertCoordinate("-0.1275", -0.1275, t)
+}
+
+func TestDegreesMinutesSecondsCoordinate(t *testing.T) {
+	assertCoordinate(`51 deg 30' 26.00" N`, 51.507222, t)
+	assertCoordinate(`0 deg 7' 39.00" W`, -0.1275, t)
+}
+
+func TestMissingCoordinate(t *testing.T) {
+	if _, err := ParseCoordinate(""); err == nil {
+		t.Error("Expected error for missing coordinate")
+	}
+}
@@ -0,0 +1,101 @@
+package archive
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/jpg0/flickrup/index"
+	"github.com/jpg0/flickrup/processing"
+	"github.com/juju/errors"
+)
+
+//marks a file as a new version of a photo already uploaded
+const FLICKR_ID_PREFIX = "flickr:id="
+
+type ReplacementFinder struct {
+	index *index.Index
+}
+
+//index may be nil, in which case only flickr:id= tags are recognised
+func NewReplacementFinder(idx *index.Index) *ReplacementFinder {
+	return &ReplacementFinder{
+		index: idx,
+	}
+}
+
+//must occur before the upload stage; recognises re-edited files by a flickr:id= tag, or by an archived
+//photo with the same name and date taken, so that they replace the existing photo
+func (rf *ReplacementFinder) Stage() processing.Stage {
+	return func(ctx *processing.ProcessingContext, next processing.Processor) processing.ProcessingResult {
+
+		//already uploaded, as found by the UploadedChecker
+		if ctx.UploadedId != "" {
+			return next(ctx)
+		}
+
+		item, err := rf.find(ctx)
+
+		if err != nil {
+			return processing.NewErrorResult(errors.Annotatef(err, "Failed to check whether %v replaces an existing photo", ctx.File.Name()))
+		}
+
+		if ctx.ReplacingId == "" {
+			return next(ctx)
+		}
+
+		log.Infof("%v is a new version of photo %v, replacing it", ctx.File.Name(), ctx.ReplacingId)
+
+		if item != nil {
+			ctx.ReplacingPath = item.Path
+
+			if ctx.Visibilty == "default" && item.Visibility != "" {
+				ctx.Visibilty = item.Visibility
+			}
+		}
+
+		return next(ctx)
+	}
+}
+
+//sets the ReplacingId, and returns the archived item for it if there is one
+func (rf *ReplacementFinder) find(ctx *processing.ProcessingContext) (*index.Item, error) {
+	ids := processing.ValuesByPrefix(ctx.File.Keywords(), FLICKR_ID_PREFIX)
+
+	if len(ids) > 1 {
+		return nil, errors.Errorf("Multiple %v tags", FLICKR_ID_PREFIX)
+	}
+
+	if len(ids) == 1 {
+		ctx.ReplacingId = ids[0]
+
+		if rf.index == nil {
+			return nil, nil
+		}
+
+		return rf.index.ByPhotoID(ids[0])
+	}
+
+	taken := ctx.File.DateTaken()
+
+	if rf.index == nil || taken.IsZero() {
+		return nil, nil
+	}
+
+	items, err := rf.index.ByNameAndDate(ctx.File.Name(), taken)
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	var match *index.Item
+
+	for _, item := range items {
+		if item.PhotoID != "" {
+			match = item
+		}
+	}
+
+	if match != nil {
+		ctx.ReplacingId = match.PhotoID
+	}
+
+	return match, nil
+}
@@ -0,0 +1,105 @@
+package archive
+
+import (
+	"github.com/jpg0/flickrup/config"
+	"github.com/jpg0/flickrup/index"
+	"github.com/jpg0/flickrup/processing"
+	"github.com/jpg0/flickrup/testlib"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func replacementFixture(t *testing.T) (string, *index.Index) {
+	dir, err := ioutil.TempDir("", "archive")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idx, err := index.New(filepath.Join(dir, "index.db"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir, idx
+}
+
+func TestReplaceByIndexMatch(t *testing.T) {
+	dir, idx := replacementFixture(t)
+	defer os.RemoveAll(dir)
+
+	taken := time.Date(2016, 5, 4, 10, 11, 12, 0, time.UTC)
+	idx.Put(&index.Item{PhotoID: "123", Path: "/archive/2016/05/photo.jpg", DateTaken: taken, Visibility: "family"})
+
+	file := testlib.NewFakeTaggedFile("photo.jpg", "/watch/photo.jpg", make(map[string]string), nil, taken)
+	ctx := processing.NewProcessingContext(&config.Config{}, file, nil)
+
+	NewReplacementFinder(idx).Stage()(ctx, processing.SuccessProcessor)
+
+	if ctx.ReplacingId != "123" || ctx.ReplacingPath != "/archive/2016/05/photo.jpg" {
+		t.Errorf("Expected to replace archived photo, got '%v' at '%v'", ctx.ReplacingId, ctx.ReplacingPath)
+	}
+
+	if ctx.Visibilty != "family" {
+		t.Errorf("Expected existing visibility to be kept, got %v", ctx.Visibilty)
+	}
+
+	other := testlib.NewFakeTaggedFile("photo.jpg", "/watch/photo.jpg", make(map[string]string), nil, taken.Add(time.Second))
+	ctx = processing.NewProcessingContext(&config.Config{}, other, nil)
+
+	NewReplacementFinder(idx).Stage()(ctx, processing.SuccessProcessor)
+
+	if ctx.ReplacingId != "" {
+		t.Errorf("Expected a photo taken at another time to be uploaded, got %v", ctx.ReplacingId)
+	}
+}
+
+func TestReplaceByTag(t *testing.T) {
+	dir, idx := replacementFixture(t)
+	defer os.RemoveAll(dir)
+
+	file := testlib.NewFakeTaggedFile("edited.jpg", "/watch/edited.jpg", make(map[string]string), []string{"flickr:id=456"}, time.Time{})
+	ctx := processing.NewProcessingContext(&config.Config{}, file, nil)
+
+	NewReplacementFinder(idx).Stage()(ctx, processing.SuccessProcessor)
+
+	if ctx.ReplacingId != "456" || ctx.ReplacingPath != "" {
+		t.Errorf("Expected to replace tagged photo, got '%v' at '%v'", ctx.ReplacingId, ctx.ReplacingPath)
+	}
+}
+
+func TestReplaceArchived(t *testing.T) {
+	dir, idx := replacementFixture(t)
+	defer os.RemoveAll(dir)
+
+	old := filepath.Join(dir, "2016", "05", "photo.jpg")
+	os.MkdirAll(filepath.Dir(old), 0755)
+	ioutil.WriteFile(old, []byte("old"), 0644)
+	idx.Put(&index.Item{PhotoID: "123", Path: old})
+
+	edited := filepath.Join(dir, "photo.png")
+	ioutil.WriteFile(edited, []byte("new"), 0644)
+
+	newPath, err := NewArchiver(idx).replaceArchived(context.Background(), edited, old)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if newPath != filepath.Join(dir, "2016", "05", "photo.png") {
+		t.Errorf("Expected new file alongside the old one, got %v", newPath)
+	}
+
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("Expected replaced file to be removed, got %v", err)
+	}
+
+	if item, _ := idx.ByPath(old); item != nil {
+		t.Errorf("Expected replaced file to be removed from the index, got %+v", item)
+	}
+}
@@ -5,10 +5,11 @@ import (
 	"fmt"
 	"github.com/Sirupsen/logrus"
 	"github.com/jpg0/flickrdown/flickraccess"
+	"github.com/jpg0/flickrup/imagehash"
+	"github.com/jpg0/flickrup/index"
 	"github.com/juju/errors"
 	"io"
 	"io/ioutil"
-	"net/http"
 	"net/url"
 	"os"
 	"strings"
@@ -43,26 +44,118 @@ func BeginBatchDownload(startAt date.Date, endAt date.Date, config *config.Confi
 
 	logrus.Infof("Processing %v days", daysToProcess)
 
+	//failures are recorded in the ledger, so that one bad photo does not stop the rest of the range
 	for day := 0; day < daysToProcess; day++ {
 		err = DownloadForDay(startAt.Add(date.PeriodOfDays(day)), ctx)
 		if err != nil {
-			return errors.Annotatef(err, "Failed to download for day %v", startAt.Add(date.PeriodOfDays(day)))
+			logrus.Errorf("Failed to download for day %v: %v", startAt.Add(date.PeriodOfDays(day)), err)
 		}
 	}
 
-	return nil
+	return finishBatch(ctx)
+}
+
+//re-processes only the photos and days recorded in the failure ledger
+func RetryFailedDownloads(config *config.Config) error {
+
+	ctx, err := buildContext(config)
+
+	if err != nil {
+		return errors.Annotate(err, "Failed to build context")
+	}
+
+	retryFailures(ctx, 0)
+
+	return finishBatch(ctx)
+}
+
+//retries ledger entries that have been attempted fewer than maxAttempts times, or all of them if maxAttempts is 0
+func retryFailures(ctx *DownloadingContext, maxAttempts int) {
+	failures := make([]*Failure, 0)
+
+	for _, f := range ctx.ledger.Failures() {
+		if maxAttempts == 0 || f.Attempts < maxAttempts {
+			failures = append(failures, f)
+		}
+	}
+
+	if len(failures) == 0 {
+		return
+	}
+
+	logrus.Infof("Retrying %v failures from %v", len(failures), ctx.ledger.path)
+
+	for _, f := range failures {
+		if ctx.stopping() {
+			return
+		}
+
+		day, err := date.Parse(input_layout, f.Day)
+
+		if err != nil {
+			logrus.Warnf("Ignoring ledger entry with invalid day %v", f.Day)
+			continue
+		}
+
+		if f.PhotoID == "" {
+			err = DownloadForDay(day, ctx)
+		} else {
+			err = processPhoto(ctx.source.Photo(f.PhotoID), day, ctx)
+		}
+
+		if err != nil {
+			logrus.Errorf("Retry of %v failed: %v", f.key(), err)
+		}
+	}
+}
+
+func finishBatch(ctx *DownloadingContext) error {
+	err := ctx.ledger.Save()
+
+	if err != nil {
+		logrus.Errorf("Failed to save failure ledger: %v", err)
+	}
+
+	return ctx.ledger.Summarise()
 }
 
 func buildContext(config *config.Config) (*DownloadingContext, error) {
 
+	store, err := flickraccess.NewTokenStore(config)
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	err = flickraccess.Preflight(config.APIKey, config.SharedSecret, store, flickraccess.PERMS_READ, "Downloading")
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
 	client, err := flickraccess.NewDownloadClient(config)
 
 	if err != nil {
 		return nil, errors.Annotatef(err, "Failed to create flickr client")
 	}
 
+	idx, err := index.New(config.IndexPath())
+
+	if err != nil {
+		return nil, errors.Annotatef(err, "Failed to open index")
+	}
+
+	ledger, err := LoadLedger(config.ArchiveDir + "/" + FAILURE_LEDGER_FILE)
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
 	return &DownloadingContext{
-		flickrclient: client,
+		source: client,
+		fetcher: flickraccess.NewHTTPContentFetcher(),
+		index: idx,
+		ledger: ledger,
 		config: config,
 	}, nil
 }
@@ -72,18 +165,33 @@ func DownloadForDay(day date.Date, ctx *DownloadingContext) error {
 	logrus.Infof("Downloading for day %v", day.Format("2006-01-02"))
 
 	//first query for all photos that day
-	batch := ctx.flickrclient.Search(day, day.Add(1))
+	batch := ctx.source.Search(day, day.Add(1))
 
 	errorChannel := make(chan error)
 
 	photoCount := 0
 
+	var searchErr error
+
 	for {
 
+		//let in-flight photos finish, but start no more
+		if ctx.stopping() {
+			break
+		}
+
 		photo, err := batch.NextPhoto()
 
 		if err != nil {
-			return errors.Annotate(err, "Failed to load photo")
+			err = errors.Annotate(err, "Failed to load photo")
+
+			if ctx.ledger != nil {
+				ctx.ledger.RecordFailure(day, "", STAGE_SEARCH, err)
+			}
+
+			//photos already started still report back below
+			searchErr = err
+			break
 		}
 
 		if photo == nil {
@@ -92,12 +200,8 @@ func DownloadForDay(day date.Date, ctx *DownloadingContext) error {
 
 		photoCount += 1
 		go func() {
-			errorChannel <- processPhoto(photo, ctx)
+			errorChannel <- processPhoto(photo, day, ctx)
 		}()
-
-		if err != nil {
-			return errors.Annotate(err, "Failed to process photo")
-		}
 	}
 
 	var rv error
@@ -112,21 +216,60 @@ func DownloadForDay(day date.Date, ctx *DownloadingContext) error {
 		}
 	}
 
+	if searchErr != nil {
+		return searchErr
+	}
+
+	if ctx.stopping() {
+		return errStopped
+	}
+
+	if ctx.ledger != nil {
+		ctx.ledger.RecordSearched(day)
+	}
+
 	logrus.Debugf("Completed downloading for day %v", day.Format("2006-01-02"))
 
 
 	return rv
 }
 
-func processPhoto(photo *flickraccess.RemotePhoto, ctx *DownloadingContext) error {
+//records the outcome in the failure ledger
+func processPhoto(photo flickraccess.RemotePhoto, day date.Date, ctx *DownloadingContext) error {
 
 	photoCtx := NewPhotoContext(ctx)
 
 	photoCtx.SetRemote(photo)
 
+	err := downloadPhoto(photoCtx)
+
+	if ctx.ledger != nil {
+		if err != nil {
+			ctx.ledger.RecordFailure(day, photo.ID(), photoCtx.Stage, err)
+		} else {
+			ctx.ledger.RecordSuccess(photo.ID())
+		}
+	}
+
+	return err
+}
+
+func downloadPhoto(photoCtx *PhotoContext) error {
+
+	ctx := photoCtx.DownloadingContext
+	photo := photoCtx.Photo
+
 	logrus.Debugf("Processing photo %v", photo.ID())
 
-	err := getFilepath(photoCtx)
+	photoCtx.Stage = STAGE_METADATA
+	_, err := photo.GetMeta()
+
+	if err != nil {
+		return errors.Annotatef(err, "Failed to get metadata: %v", photo.ID())
+	}
+
+	photoCtx.Stage = STAGE_PATH
+	err = getFilepath(photoCtx)
 
 	if err != nil {
 		return errors.Annotatef(err, "Failed to determine file path for photo: %v", err)
@@ -138,17 +281,48 @@ func processPhoto(photo *flickraccess.RemotePhoto, ctx *DownloadingContext) erro
 		return errors.Annotatef(err, "Failed to write date for photo: %v", err)
 	}
 
+	if ctx.index != nil && photoCtx.DownloadedAs != "" {
+		meta, _ := photo.GetMeta()
+		item := indexItem(meta, photoCtx.DownloadedAs)
+		item.Checksum = photoCtx.Checksum
+		item.PerceptualHash = photoCtx.PerceptualHash
+		err = ctx.index.Put(item)
+
+		if err != nil {
+			logrus.Warnf("Failed to index photo %v: %v", photo.ID(), err)
+		}
+	}
+
 	return nil
 }
 
 func downloadAndWriteData(photoCtx *PhotoContext) error {
-	//write meta
 	meta, err := photoCtx.Photo.GetMeta()
 
 	if err != nil {
 		return errors.Annotatef(err, "Failed to get metadata: %v", photoCtx.Photo.ID())
 	}
 
+	urlToFetch, fileextension, err := originalSource(meta)
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	target := photoCtx.Filepath + "." + fileextension
+
+	if deduplicated(photoCtx.DownloadingContext, target) {
+		logrus.Infof("Not downloading %v as it was previously deduplicated", target)
+		return nil
+	}
+
+	photoCtx.Stage = STAGE_WRITE_META
+	meta.Location, err = photoCtx.Photo.GetLocation()
+
+	if err != nil {
+		return errors.Annotatef(err, "Failed to get location: %v", photoCtx.Photo.ID())
+	}
+
 	asJson, err := json.Marshal(meta)
 
 	if err != nil {
@@ -162,7 +336,33 @@ func downloadAndWriteData(photoCtx *PhotoContext) error {
 		return errors.Annotatef(err, "Failed to write meta file: %v", meta.Title)
 	}
 
-	//
+	photoCtx.Stage = STAGE_DOWNLOAD
+	logrus.Debugf("Writing file for %v to %v", meta.Title, target)
+	checksum, err := DownloadFile(photoCtx.DownloadingContext.fetcher, target, urlToFetch)
+
+	if err != nil {
+		return errors.Annotatef(err, "Failed to download file: %v", err)
+	}
+
+	photoCtx.DownloadedAs = target
+	photoCtx.Checksum = checksum
+
+	if imagehash.Supported(target) {
+		hash, err := imagehash.HashFile(target)
+
+		if err != nil {
+			logrus.Warnf("Failed to compute perceptual hash of %v: %v", target, err)
+		} else {
+			photoCtx.PerceptualHash = imagehash.Format(hash)
+		}
+	}
+
+	return nil
+
+}
+
+//returns the URL of the original and its file extension
+func originalSource(meta *flickraccess.Meta) (string, string, error) {
 	urlToFetch := ""
 
 	for i := range meta.SizeList {
@@ -172,14 +372,14 @@ func downloadAndWriteData(photoCtx *PhotoContext) error {
 	}
 
 	if urlToFetch == "" {
-		return errors.Errorf("Failed to find original download URL for photo %s", meta.Title)
+		return "", "", errors.Errorf("Failed to find original download URL for photo %s", meta.Title)
 	}
 
 	urlObj, err := url.Parse(urlToFetch)
 	fileextension := "jpg"
 
 	if err != nil {
-		return errors.Annotate(err,"Failed to parse URL for photo")
+		return "", "", errors.Annotate(err,"Failed to parse URL for photo")
 	}
 
 	segments := strings.Split(urlObj.Path, "/")
@@ -191,15 +391,54 @@ func downloadAndWriteData(photoCtx *PhotoContext) error {
 		fileextension = parts[len(parts) - 1]
 	}
 
-	logrus.Debugf("Writing file for %v to %v", meta.Title, photoCtx.Filepath + "." + fileextension)
-	err = DownloadFile(photoCtx.Filepath + "." + fileextension, urlToFetch)
+	return urlToFetch, fileextension, nil
+}
+
+func indexItem(meta *flickraccess.Meta, path string) *index.Item {
+	taken, err := time.Parse(flicrkDateFormat, meta.Dates.Taken)
 
 	if err != nil {
-		return errors.Annotatef(err, "Failed to download file: %v", err)
+		logrus.Warnf("Failed to parse date taken for %v: %v", meta.Id, meta.Dates.Taken)
 	}
 
-	return nil
+	item := &index.Item{
+		PhotoID: meta.Id,
+		Path: path,
+		Tags: make([]string, len(meta.Tags)),
+		Sets: make([]string, len(meta.Sets)),
+		DateTaken: taken,
+		Visibility: visibilityOf(meta),
+	}
+
+	for i := range meta.Tags {
+		item.Tags[i] = meta.Tags[i].Raw
+	}
+
+	for i := range meta.Sets {
+		item.Sets[i] = meta.Sets[i].Title
+	}
+
+	if meta.Location != nil {
+		item.HasGeo = true
+		item.Latitude = meta.Location.Latitude
+		item.Longitude = meta.Location.Longitude
+	}
 
+	return item
+}
+
+//uses the same names as the upload visibility tags
+func visibilityOf(meta *flickraccess.Meta) string {
+	switch {
+	case meta.Visibility.IsPublic:
+		return "public"
+	case meta.Visibility.IsFriend:
+		return "friends"
+	case meta.Visibility.IsFamily:
+		return "family"
+	default:
+		return "private"
+	}
 }
 
 func getFilepath(photoCtx *PhotoContext) error {
@@ -243,15 +482,29 @@ func getFilepath(photoCtx *PhotoContext) error {
 }
 
 type DownloadingContext struct {
-	flickrclient *flickraccess.FlickrDownloadClient
-	config       *config.Config
+	source  flickraccess.SearchSource
+	fetcher flickraccess.ContentFetcher
+	index   *index.Index
+	ledger  *Ledger
+	config  *config.Config
+	stop    <-chan struct{} //closed when downloading should stop, nil if it never will
+}
+
+var errStopped = errors.New("Stopped before completing")
+
+func (ctx *DownloadingContext) stopping() bool {
+	return stopped(ctx.stop)
 }
 
 
 type PhotoContext struct {
 	DownloadingContext *DownloadingContext
-	Photo *flickraccess.RemotePhoto
+	Photo flickraccess.RemotePhoto
 	Filepath string
+	DownloadedAs string
+	Checksum string
+	PerceptualHash string
+	Stage string //how far processing got, reported if it fails
 }
 
 func NewPhotoContext(DownloadingContext *DownloadingContext) *PhotoContext {
@@ -260,31 +513,49 @@ func NewPhotoContext(DownloadingContext *DownloadingContext) *PhotoContext {
 	}
 }
 
-func (pc *PhotoContext) SetRemote(photo *flickraccess.RemotePhoto) {
+func (pc *PhotoContext) SetRemote(photo flickraccess.RemotePhoto) {
 	pc.Photo = photo
 }
 
-func DownloadFile(filepath string, url string) error {
+//returns the SHA-256 of the downloaded content
+func DownloadFile(fetcher flickraccess.ContentFetcher, filepath string, url string) (string, error) {
+
+	// Get the data
+	body, err := fetcher.Fetch(url)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
 
 	// Create the file
 	out, err := os.Create(filepath)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer out.Close()
 
-	// Get the data
-	resp, err := http.Get(url)
+	// Write the body to file, hashing as we go
+	hasher := index.NewHasher()
+	_, err = io.Copy(io.MultiWriter(out, hasher), body)
 	if err != nil {
-		return err
+		return "", err
 	}
-	defer resp.Body.Close()
 
-	// Write the body to file
-	_, err = io.Copy(out, resp.Body)
+	return index.Checksum(hasher), nil
+}
+
+//whether a dedupe run has replaced or removed the file at path
+func deduplicated(ctx *DownloadingContext, path string) bool {
+	if ctx.index == nil {
+		return false
+	}
+
+	decision, err := ctx.index.DecisionFor(path)
+
 	if err != nil {
-		return err
+		logrus.Warnf("Failed to check dedupe decisions for %v: %v", path, err)
+		return false
 	}
 
-	return nil
-}
\ No newline at end of file
+	return decision != nil
+}
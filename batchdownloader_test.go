@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/golang/mock/gomock"
+	"github.com/jpg0/flickrdown/config"
+	"github.com/jpg0/flickrdown/flickraccess"
+	"github.com/jpg0/flickrdown/mocks"
+	"github.com/rickb777/date"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+const testMeta = `{
+	"Id": "123",
+	"Title": "beach",
+	"Dates": {"Taken": "2016-05-04 10:11:12"},
+	"Sets": [{"Title": "Holiday"}],
+	"SizeList": [{"Label": "Original", "Source": "https://farm1.staticflickr.com/1/123_abc_o.png"}]
+}`
+
+func newTestMeta(t *testing.T) *flickraccess.Meta {
+	meta := &flickraccess.Meta{}
+
+	err := json.Unmarshal([]byte(testMeta), meta)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return meta
+}
+
+func newTestContext(t *testing.T, source flickraccess.SearchSource, fetcher flickraccess.ContentFetcher) *DownloadingContext {
+	dir, err := ioutil.TempDir("", "flickrdown")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &DownloadingContext{
+		source: source,
+		fetcher: fetcher,
+		ledger: NewLedger(dir + "/" + FAILURE_LEDGER_FILE),
+		config: &config.Config{ArchiveDir: dir},
+	}
+}
+
+func TestDownloadForDay(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	source := mock_flickr.NewMockSearchSource(mockCtrl)
+	batch := mock_flickr.NewMockPhotoBatch(mockCtrl)
+	photo := mock_flickr.NewMockRemotePhoto(mockCtrl)
+	fetcher := mock_flickr.NewMockContentFetcher(mockCtrl)
+
+	day := date.New(2016, 5, 4)
+
+	source.EXPECT().Search(day, day.Add(1)).Return(batch)
+	gomock.InOrder(
+		batch.EXPECT().NextPhoto().Return(photo, nil),
+		batch.EXPECT().NextPhoto().Return(nil, nil),
+	)
+	photo.EXPECT().ID().Return("123").AnyTimes()
+	photo.EXPECT().GetMeta().Return(newTestMeta(t), nil).AnyTimes()
+	photo.EXPECT().GetLocation().Return(&flickraccess.Location{Latitude: 51.5, Longitude: -0.1}, nil)
+	fetcher.EXPECT().Fetch("https://farm1.staticflickr.com/1/123_abc_o.png").Return(ioutil.NopCloser(strings.NewReader("content")), nil)
+
+	ctx := newTestContext(t, source, fetcher)
+	defer os.RemoveAll(ctx.config.ArchiveDir)
+
+	err := DownloadForDay(day, ctx)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base := ctx.config.ArchiveDir + "/2016/05/Holiday/beach"
+
+	data, err := ioutil.ReadFile(base + ".png")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertEquals("content", string(data), t)
+
+	meta, err := ioutil.ReadFile(base + ".meta")
+
+	if err != nil || !strings.Contains(string(meta), `"Latitude":51.5`) {
+		t.Errorf("Expected meta file to be written with the location, got %s (%v)", meta, err)
+	}
+}
+
+func TestDownloadForDayReportsFetchFailure(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	source := mock_flickr.NewMockSearchSource(mockCtrl)
+	batch := mock_flickr.NewMockPhotoBatch(mockCtrl)
+	photo := mock_flickr.NewMockRemotePhoto(mockCtrl)
+	fetcher := mock_flickr.NewMockContentFetcher(mockCtrl)
+
+	day := date.New(2016, 5, 4)
+
+	source.EXPECT().Search(day, day.Add(1)).Return(batch)
+	gomock.InOrder(
+		batch.EXPECT().NextPhoto().Return(photo, nil),
+		batch.EXPECT().NextPhoto().Return(nil, nil),
+	)
+	photo.EXPECT().ID().Return("123").AnyTimes()
+	photo.EXPECT().GetMeta().Return(newTestMeta(t), nil).AnyTimes()
+	photo.EXPECT().GetLocation().Return(nil, nil).AnyTimes()
+	fetcher.EXPECT().Fetch(gomock.Any()).Return(nil, errors.New("connection reset"))
+
+	ctx := newTestContext(t, source, fetcher)
+	defer os.RemoveAll(ctx.config.ArchiveDir)
+
+	err := DownloadForDay(day, ctx)
+
+	if err == nil {
+		t.Error("Expected failure to be reported")
+	}
+
+	failures := ctx.ledger.Failures()
+
+	if len(failures) != 1 || failures[0].PhotoID != "123" || failures[0].Stage != STAGE_DOWNLOAD || failures[0].Day != "2016-05-04" {
+		t.Errorf("Expected download failure of 123 in ledger, got %+v", failures)
+	}
+}
+
+func TestDownloadForDayWaitsForStartedPhotosWhenSearchFails(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	source := mock_flickr.NewMockSearchSource(mockCtrl)
+	batch := mock_flickr.NewMockPhotoBatch(mockCtrl)
+	photo := mock_flickr.NewMockRemotePhoto(mockCtrl)
+	fetcher := mock_flickr.NewMockContentFetcher(mockCtrl)
+
+	day := date.New(2016, 5, 4)
+
+	source.EXPECT().Search(day, day.Add(1)).Return(batch)
+	gomock.InOrder(
+		batch.EXPECT().NextPhoto().Return(photo, nil),
+		batch.EXPECT().NextPhoto().Return(nil, errors.New("page 2 unavailable")),
+	)
+	photo.EXPECT().ID().Return("123").AnyTimes()
+	photo.EXPECT().GetMeta().Return(newTestMeta(t), nil).AnyTimes()
+	photo.EXPECT().GetLocation().Return(nil, nil).AnyTimes()
+	fetcher.EXPECT().Fetch(gomock.Any()).Return(ioutil.NopCloser(strings.NewReader("content")), nil)
+
+	ctx := newTestContext(t, source, fetcher)
+	defer os.RemoveAll(ctx.config.ArchiveDir)
+
+	err := DownloadForDay(day, ctx)
+
+	if err == nil {
+		t.Error("Expected the search failure to be reported")
+	}
+
+	//the photo already started has finished, rather than being abandoned
+	if _, err := os.Stat(ctx.config.ArchiveDir + "/2016/05/Holiday/beach.png"); err != nil {
+		t.Errorf("Expected the started photo to be downloaded: %v", err)
+	}
+
+	failures := ctx.ledger.Failures()
+
+	if len(failures) != 1 || failures[0].Stage != STAGE_SEARCH {
+		t.Errorf("Expected only the search failure in the ledger, got %+v", failures)
+	}
+}
+
+func TestRetryFailedPhoto(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	source := mock_flickr.NewMockSearchSource(mockCtrl)
+	photo := mock_flickr.NewMockRemotePhoto(mockCtrl)
+	fetcher := mock_flickr.NewMockContentFetcher(mockCtrl)
+
+	ctx := newTestContext(t, source, fetcher)
+	defer os.RemoveAll(ctx.config.ArchiveDir)
+
+	day := date.New(2016, 5, 4)
+	ctx.ledger.RecordFailure(day, "123", STAGE_DOWNLOAD, errors.New("connection reset"))
+
+	err := ctx.ledger.Save()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx.ledger, err = LoadLedger(ctx.ledger.path)
+
+	if err != nil || len(ctx.ledger.Failures()) != 1 {
+		t.Fatalf("Expected saved failure to load, got %v (%v)", ctx.ledger.Failures(), err)
+	}
+
+	source.EXPECT().Photo("123").Return(photo)
+	photo.EXPECT().ID().Return("123").AnyTimes()
+	photo.EXPECT().GetMeta().Return(newTestMeta(t), nil).AnyTimes()
+	photo.EXPECT().GetLocation().Return(nil, nil).AnyTimes()
+	fetcher.EXPECT().Fetch(gomock.Any()).Return(ioutil.NopCloser(strings.NewReader("content")), nil)
+
+	err = processPhoto(ctx.source.Photo("123"), day, ctx)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ctx.ledger.Failures()) != 0 {
+		t.Errorf("Expected retried photo to be removed from ledger, got %+v", ctx.ledger.Failures())
+	}
+}
+
+func TestSummariseOnlyFailsForThisRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flickrdown")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	earlier := NewLedger(dir + "/" + FAILURE_LEDGER_FILE)
+	earlier.RecordFailure(date.New(2016, 5, 4), "", STAGE_SEARCH, errors.New("timeout"))
+	earlier.Save()
+
+	ledger, err := LoadLedger(earlier.path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ledger.Summarise(); err != nil {
+		t.Errorf("Expected a day left from an earlier run only to be reported, got %v", err)
+	}
+
+	ledger.RecordFailure(date.New(2016, 5, 5), "", STAGE_SEARCH, errors.New("timeout"))
+
+	if err := ledger.Summarise(); err == nil {
+		t.Error("Expected a day failing in this run to fail it")
+	}
+}
+
+func assertEquals(expected string, actual string, t *testing.T) {
+	if actual != expected {
+		t.Errorf("Test failed, expected: '%s', got:  '%s'", expected, actual)
+	}
+}
@@ -4,45 +4,522 @@ import (
 	"encoding/json"
 	"github.com/Sirupsen/logrus"
 	"github.com/juju/errors"
-	//"gopkg.in/yaml.v2"
+	"github.com/robfig/cron"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 )
 
 type Config struct {
+	Version int `json:"version"`
 	APIKey string `json:"api_key"`
 	SharedSecret string `json:"shared_secret"`
 	ArchiveDir string `json:"archive_dir"`
-	TagsetPrefix string `json:"tagsetprefix"`
-	VisibilityPrefix string `json:"visibilityprefix"`
-	StateFile string `json:"statefile"`
-	//TagReplacements map[string]map[string]string `json:"tag_replacements"`
-	//BlockedTags map[string]string `json:"blocked_tags"`
-	//ConvertFiles map[string][]string `json:"convert_files"`
-	//TransferService *TransferService `json:"transfer_service"`
+	WatchDir string `json:"watch_dir"`
+	WatchDirs []*WatchDir `json:"watch_dirs"` //further roots to watch, each with its own profile
+	WatchRecursive bool `json:"watch_recursive"` //also watch and upload from subdirectories
+	WatchMode string `json:"watch_mode"` //auto, notify or poll; auto polls on network and FUSE filesystems, or if notifications fail
+	WatchPollInterval string `json:"watch_poll_interval"` //how often to poll for changes, defaults to 30s
+	Include []string `json:"include"` //globs, or regexes prefixed re:, of the file names to upload; empty for all
+	Exclude []string `json:"exclude"` //file names never to upload, in addition to the default excludes
+	DefaultExcludes bool `json:"default_excludes"` //whether temporary and sync files are excluded, defaults to true
+	StabilityWindow string `json:"stability_window"` //how long a file must be unchanged before uploading, defaults to 5m
+	StabilityPolls int `json:"stability_polls"` //how many checks within the window must find it unchanged, defaults to 3
+	StabilityExclusiveOpen bool `json:"stability_exclusive_open"` //also wait while another process holds a lock on the file
+	SelectionPolicy string `json:"selection_policy"` //strict, skip_untagged, per_folder or deadline; which files a run uploads
+	SelectionDeadline string `json:"selection_deadline"` //how long an untagged file waits before getting the defaults, e.g. 72h
+	DefaultTags []string `json:"default_tags"`
+	DefaultVisibility string `json:"default_visibility"`
+	UntaggedVisibility string `json:"untagged_visibility"` //for files without a visibility tag, instead of the flickr account default
+	DefaultSets []string `json:"default_sets"` //added to every upload as well as the sets from tags
+	Timezone string `json:"timezone"` //in which days begin and end when downloading, defaults to UTC
+	TagsetPrefix string `json:"tagset_prefix"`
+	VisibilityPrefix string `json:"visibility_prefix"`
+	StateFile string `json:"state_file"`
+	IndexFile string `json:"index_file"`
+	JournalFile string `json:"journal_file"`
+	QuarantineDir string `json:"quarantine_dir"`
+	NearDuplicates string `json:"near_duplicates"`
+	NearDuplicateDistance int `json:"near_duplicate_distance"`
+	DuplicateUploads string `json:"duplicate_uploads"` //skip or attach files whose content was already uploaded
+	ChecksumTags bool `json:"checksum_tags"` //tag uploads with checksum:sha1=, so that duplicates can be found on flickr
+	TokenFile string `json:"token_file"`
+	TokenStore *TokenStore `json:"token_store"`
+	Accounts map[string]*Account `json:"accounts"`
+	Account string `json:"-"`
+	TagReplacements map[string]map[string]string `json:"tag_replacements"` //tag name -> $keyword -> value to set when the keyword is present
+	BlockedTags map[string]string `json:"blocked_tags"` //tag name -> value which prevents upload
+	ConvertFiles map[string][]string `json:"convert_files"` //lower case extension -> command, with ${file} expanded
+	TransferService *TransferService `json:"transfer_service"`
+	StageTimeouts map[string]string `json:"stage_timeouts"` //convert, upload, sets or archive -> how long the stage may take, e.g. 10m
+	DownloadSchedule string `json:"download_schedule"` //how often download --daemon syncs, as an interval such as 1h or a cron expression
+	DownloadLookback string `json:"download_lookback"` //how far back the first daemon sync looks, e.g. 168h
 }
 
-//type TransferService struct {
-//	Password string `json:"password"`
-//	DropboxDirMapping map[string]string `json:"dropbox_dir_mapping"`
-//}
+//settings which differ between Flickr accounts; empty fields fall back to the top level config
+type Account struct {
+	APIKey string `json:"api_key"`
+	SharedSecret string `json:"shared_secret"`
+	ArchiveDir string `json:"archive_dir"`
+	TokenFile string `json:"token_file"`
+}
+
+//where OAuth tokens are kept; defaults to a file readable only by its owner
+type TokenStore struct {
+	Type string `json:"type"` //file, encrypted or command
+	PassphraseEnv string `json:"passphrase_env"`
+	Command []string `json:"command"`
+}
+
+//a watch root and the settings which differ for files uploaded from it
+type WatchDir struct {
+	Dir string `json:"dir"`
+	ArchiveDir string `json:"archive_dir"`
+	TagsetPrefix string `json:"tagset_prefix"`
+	VisibilityPrefix string `json:"visibility_prefix"`
+	UntaggedVisibility string `json:"untagged_visibility"`
+	DefaultSets []string `json:"default_sets"`
+}
+
+//uploads files from Dropbox on our behalf, rather than sending them from this machine
+type TransferService struct {
+	Password string `json:"password"`
+	DropboxDirMapping map[string]string `json:"dropbox_dir_mapping"` //local dir -> the same dir within the local Dropbox folder
+}
+
+//the defaults which a loaded config file is applied over
+func Default() *Config {
+	return &Config{
+		Version: CURRENT_VERSION,
+		NearDuplicateDistance: 10,
+		DefaultExcludes: true,
+		TokenStore: &TokenStore{
+			Type: "file",
+			PassphraseEnv: "FLICKRUP_TOKEN_PASSPHRASE",
+		},
+	}
+}
+
+//maps a local path onto the Dropbox folder that the transfer service reads from, using the longest matching directory.
+//Paths outside every mapped directory are returned unchanged.
+func (ts *TransferService) MapDropboxPath(path string) string {
+	path = filepath.Clean(path)
+	bestFrom := ""
+	bestTo := ""
+
+	for from, to := range ts.DropboxDirMapping {
+		from = filepath.Clean(os.ExpandEnv(from))
+
+		if len(from) <= len(bestFrom) {
+			continue
+		}
+
+		if path == from || strings.HasPrefix(path, strings.TrimSuffix(from, string(filepath.Separator)) + string(filepath.Separator)) {
+			bestFrom = from
+			bestTo = os.ExpandEnv(to)
+		}
+	}
+
+	if bestFrom == "" {
+		return path
+	}
+
+	return filepath.Join(bestTo, path[len(bestFrom):])
+}
+
+func (config *Config) TokenPath() string {
+	if config.TokenFile != "" {
+		return os.ExpandEnv(config.TokenFile)
+	}
+
+	if config.Account != "" {
+		return os.ExpandEnv("${HOME}/.flickrup-" + config.Account)
+	}
+
+	return os.ExpandEnv("${HOME}/.flickrup")
+}
+
+func (config *Config) TokenStoreType() string {
+	if config.TokenStore == nil || config.TokenStore.Type == "" {
+		return "file"
+	}
+
+	return config.TokenStore.Type
+}
+
+func (config *Config) TokenPassphraseEnv() string {
+	if config.TokenStore == nil || config.TokenStore.PassphraseEnv == "" {
+		return "FLICKRUP_TOKEN_PASSPHRASE"
+	}
+
+	return config.TokenStore.PassphraseEnv
+}
+
+func (config *Config) TokenCommand() []string {
+	if config.TokenStore == nil {
+		return nil
+	}
+
+	return config.TokenStore.Command
+}
+
+//the account name given to external token stores
+func (config *Config) AccountName() string {
+	if config.Account == "" {
+		return "default"
+	}
+
+	return config.Account
+}
+
+func (config *Config) AccountNames() []string {
+	rv := make([]string, 0, len(config.Accounts))
+
+	for name := range config.Accounts {
+		rv = append(rv, name)
+	}
+
+	sort.Strings(rv)
+
+	return rv
+}
+
+//returns a copy of the config with the named account applied.
+//Each account gets its own token, archive, index and quarantine unless configured otherwise.
+func (config *Config) ForAccount(name string) (*Config, error) {
+	if name == "" {
+		return config, nil
+	}
+
+	account := config.Accounts[name]
+
+	if account == nil {
+		return nil, errors.Errorf("Unknown account: %v", name)
+	}
+
+	rv := *config
+	rv.Account = name
+	rv.TokenFile = account.TokenFile
+	rv.IndexFile = ""
+	rv.QuarantineDir = ""
+
+	if account.APIKey != "" {
+		rv.APIKey = account.APIKey
+		rv.SharedSecret = account.SharedSecret
+	}
+
+	if account.ArchiveDir != "" {
+		rv.ArchiveDir = account.ArchiveDir
+	} else {
+		rv.ArchiveDir = config.ArchiveDir + "/" + name
+	}
+
+	return &rv, nil
+}
+
+//a config for each watch root: the watch_dir with the top level settings, then each of the watch_dirs over them.
+//The index and journal stay shared, so that duplicates are found across roots.
+func (config *Config) WatchProfiles() []*Config {
+	profiles := make([]*Config, 0)
+
+	if config.WatchDir != "" {
+		profiles = append(profiles, config)
+	}
+
+	for _, wd := range config.WatchDirs {
+		rv := *config
+		rv.WatchDir = wd.Dir
+		rv.IndexFile = config.IndexPath()
+		rv.JournalFile = config.JournalPath()
+
+		if wd.ArchiveDir != "" {
+			rv.ArchiveDir = wd.ArchiveDir
+		}
+
+		if wd.TagsetPrefix != "" {
+			rv.TagsetPrefix = wd.TagsetPrefix
+		}
+
+		if wd.VisibilityPrefix != "" {
+			rv.VisibilityPrefix = wd.VisibilityPrefix
+		}
+
+		if wd.UntaggedVisibility != "" {
+			rv.UntaggedVisibility = wd.UntaggedVisibility
+		}
+
+		if wd.DefaultSets != nil {
+			rv.DefaultSets = wd.DefaultSets
+		}
+
+		profiles = append(profiles, &rv)
+	}
+
+	return profiles
+}
+
+func (config *Config) WatchRoots() []string {
+	roots := make([]string, 0)
+
+	for _, profile := range config.WatchProfiles() {
+		roots = append(roots, profile.WatchDir)
+	}
+
+	return roots
+}
+
+func (config *Config) Location() (*time.Location, error) {
+	if config.Timezone == "" {
+		return time.UTC, nil
+	}
+
+	loc, err := time.LoadLocation(config.Timezone)
+
+	if err != nil {
+		return nil, errors.Annotatef(err, "Unknown timezone %v", config.Timezone)
+	}
+
+	return loc, nil
+}
+
+//accepts either a Go duration, run at that interval, or a standard 5 field cron expression
+func (config *Config) Schedule() (cron.Schedule, error) {
+	spec := config.DownloadSchedule
+
+	if spec == "" {
+		spec = "1h"
+	}
+
+	if interval, err := time.ParseDuration(spec); err == nil {
+		if interval < time.Minute {
+			return nil, errors.Errorf("download_schedule %v is shorter than a minute", spec)
+		}
+
+		return cron.Every(interval), nil
+	}
+
+	schedule, err := cron.ParseStandard(spec)
+
+	if err != nil {
+		return nil, errors.Annotatef(err, "download_schedule %v is neither an interval nor a cron expression", spec)
+	}
+
+	return schedule, nil
+}
+
+func (config *Config) Stability() (time.Duration, int, error) {
+	window := 5 * time.Minute
+	polls := 3
+
+	if config.StabilityWindow != "" {
+		var err error
+		window, err = time.ParseDuration(config.StabilityWindow)
+
+		if err != nil || window < 0 {
+			return 0, 0, errors.Errorf("stability_window %v must be a duration such as 5m", config.StabilityWindow)
+		}
+	}
+
+	if config.StabilityPolls != 0 {
+		polls = config.StabilityPolls
+	}
+
+	if polls < 1 {
+		return 0, 0, errors.Errorf("stability_polls must be at least 1")
+	}
+
+	return window, polls, nil
+}
+
+func (config *Config) Watching() string {
+	if config.WatchMode == "" {
+		return "auto"
+	}
+
+	return config.WatchMode
+}
+
+func (config *Config) PollInterval() (time.Duration, error) {
+	if config.WatchPollInterval == "" {
+		return 30 * time.Second, nil
+	}
+
+	interval, err := time.ParseDuration(config.WatchPollInterval)
+
+	if err != nil || interval < time.Second {
+		return 0, errors.Errorf("watch_poll_interval %v must be a duration of at least 1s, such as 30s", config.WatchPollInterval)
+	}
+
+	return interval, nil
+}
+
+//the timeout for the named stage, or 0 if it may take as long as it needs
+func (config *Config) StageTimeout(stage string) (time.Duration, error) {
+	value := config.StageTimeouts[stage]
+
+	if value == "" {
+		return 0, nil
+	}
+
+	timeout, err := time.ParseDuration(value)
+
+	if err != nil || timeout <= 0 {
+		return 0, errors.Errorf("stage_timeouts.%v %v must be a duration such as 10m", stage, value)
+	}
+
+	return timeout, nil
+}
+
+func (config *Config) Policy() string {
+	if config.SelectionPolicy == "" {
+		return "strict"
+	}
+
+	return config.SelectionPolicy
+}
+
+func (config *Config) Deadline() (time.Duration, error) {
+	deadline, err := time.ParseDuration(config.SelectionDeadline)
+
+	if err != nil || deadline <= 0 {
+		return 0, errors.Errorf("selection_deadline %v must be a positive duration such as 72h", config.SelectionDeadline)
+	}
+
+	return deadline, nil
+}
+
+func (config *Config) Lookback() (time.Duration, error) {
+	if config.DownloadLookback == "" {
+		return 7 * 24 * time.Hour, nil
+	}
+
+	lookback, err := time.ParseDuration(config.DownloadLookback)
+
+	if err != nil || lookback <= 0 {
+		return 0, errors.Errorf("download_lookback %v must be a positive duration such as 168h", config.DownloadLookback)
+	}
+
+	return lookback, nil
+}
+
+func (config *Config) IndexPath() string {
+	if config.IndexFile != "" {
+		return config.IndexFile
+	}
+
+	return config.ArchiveDir + "/.flickr-index.db"
+}
+
+//records uploads in progress, so that they can be resumed after a crash
+func (config *Config) JournalPath() string {
+	if config.JournalFile != "" {
+		return config.JournalFile
+	}
+
+	return config.ArchiveDir + "/.flickrup-journal"
+}
+
+func (config *Config) QuarantinePath() string {
+	if config.QuarantineDir != "" {
+		return config.QuarantineDir
+	}
+
+	return config.ArchiveDir + "/.quarantine"
+}
+
+//the largest perceptual hash distance at which two images are considered the same picture
+func (config *Config) NearDuplicateMaxDistance() int {
+	if config.NearDuplicateDistance > 0 {
+		return config.NearDuplicateDistance
+	}
+
+	return 10
+}
 
 func LoadTo(filepath string, target interface{}) error {
+	values, err := readValues(filepath)
+
+	if err != nil {
+		return err
+	}
+
+	return decodeValues(filepath, values, target)
+}
+
+func readValues(filepath string) (map[string]interface{}, error) {
 	bytes, err := ioutil.ReadFile(filepath)
 
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	values, err := toMap(bytes, Format(filepath))
+
+	if err != nil {
+		return nil, errors.Annotatef(err, "Failed to parse %v", filepath)
+	}
+
+	return values, nil
+}
+
+func decodeValues(filepath string, values map[string]interface{}, target interface{}) error {
+	asJson, err := json.Marshal(values)
+
 	if err != nil {
 		return errors.Trace(err)
 	}
 
-	err = json.Unmarshal(bytes, target)
+	err = decodeStrict(asJson, target)
 
 	if err != nil {
-		logrus.Debugf("Loaded config %s", filepath)
+		return errors.Annotatef(err, "Failed to load %v", filepath)
 	}
 
-	return err
+	logrus.Debugf("Loaded config %s", filepath)
+
+	return nil
+}
+
+//loads the config over the defaults, migrating older versions and applying environment overrides, without validating it
+func Read(filepath string) (*Config, error) {
+	values, err := readValues(filepath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = migrate(filepath, values)
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	rv := Default()
+
+	err = decodeValues(filepath, values, rv)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = ApplyEnvironment(rv, EnvPrefixes...)
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return rv, nil
 }
 
 func Load(filepath string) (*Config, error) {
-	rv := new(Config)
-	return rv, LoadTo(filepath, rv)
+	rv, err := Read(filepath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return rv, rv.Validate()
 }
@@ -0,0 +1,161 @@
+package config
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestForAccount(t *testing.T) {
+	config := &Config{
+		APIKey: "key",
+		SharedSecret: "secret",
+		ArchiveDir: "/archive",
+		Accounts: map[string]*Account{
+			"alice": {},
+			"bob": {
+				APIKey: "bobkey",
+				SharedSecret: "bobsecret",
+				ArchiveDir: "/bob",
+				TokenFile: "/tokens/bob",
+			},
+		},
+	}
+
+	alice, err := config.ForAccount("alice")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertEquals("key", alice.APIKey, t)
+	assertEquals("/archive/alice", alice.ArchiveDir, t)
+	assertEquals(os.ExpandEnv("${HOME}/.flickrup-alice"), alice.TokenPath(), t)
+
+	bob, err := config.ForAccount("bob")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertEquals("bobkey", bob.APIKey, t)
+	assertEquals("/bob/.flickr-index.db", bob.IndexPath(), t)
+	assertEquals("/tokens/bob", bob.TokenPath(), t)
+
+	if _, err := config.ForAccount("carol"); err == nil {
+		t.Error("Expected unknown account to fail")
+	}
+}
+
+func assertEquals(expected string, actual string, t *testing.T) {
+	if actual != expected {
+		t.Errorf("Test failed, expected: '%s', got:  '%s'", expected, actual)
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	from := time.Date(2016, 5, 4, 10, 30, 0, 0, time.UTC)
+
+	for spec, expected := range map[string]time.Time{
+		"":          from.Add(time.Hour),
+		"15m":       from.Add(15 * time.Minute),
+		"0 3 * * *": time.Date(2016, 5, 5, 3, 0, 0, 0, time.UTC),
+	} {
+		schedule, err := (&Config{DownloadSchedule: spec}).Schedule()
+
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", spec, err)
+		}
+
+		assertEquals(expected.String(), schedule.Next(from).String(), t)
+	}
+
+	for _, spec := range []string{"10s", "every day"} {
+		if _, err := (&Config{DownloadSchedule: spec}).Schedule(); err == nil {
+			t.Errorf("Expected %q to be rejected", spec)
+		}
+	}
+}
+
+func TestWatchProfiles(t *testing.T) {
+	config := &Config{
+		ArchiveDir: "/archive",
+		WatchDir: "/watch",
+		TagsetPrefix: "set:",
+		UntaggedVisibility: "private",
+		WatchDirs: []*WatchDir{
+			{
+				Dir: "/phone",
+				ArchiveDir: "/phone-archive",
+				UntaggedVisibility: "family",
+				DefaultSets: []string{"Phone"},
+			},
+		},
+	}
+
+	profiles := config.WatchProfiles()
+
+	if len(profiles) != 2 {
+		t.Fatalf("Expected 2 profiles, got %v", len(profiles))
+	}
+
+	assertEquals("/watch", profiles[0].WatchDir, t)
+	assertEquals("private", profiles[0].UntaggedVisibility, t)
+
+	phone := profiles[1]
+	assertEquals("/phone", phone.WatchDir, t)
+	assertEquals("/phone-archive", phone.ArchiveDir, t)
+	assertEquals("family", phone.UntaggedVisibility, t)
+	assertEquals("set:", phone.TagsetPrefix, t)
+	assertEquals("Phone", phone.DefaultSets[0], t)
+	assertEquals("/archive/.flickr-index.db", phone.IndexPath(), t)
+	assertEquals("/archive/.flickrup-journal", phone.JournalPath(), t)
+}
+
+func TestFileFilter(t *testing.T) {
+	config := Default()
+	config.Include = []string{"*.jpg", "re:^VID_\\d+\\.mp4$"}
+	config.Exclude = []string{"*_draft.*"}
+
+	filter, err := config.FileFilter()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, expected := range map[string]bool{
+		"/watch/IMG_0001.jpg":                           true,
+		"/watch/sub/IMG_0002.JPG":                       true,
+		"/watch/VID_20160504.mp4":                       true,
+		"/watch/clip.mp4":                               false,
+		"/watch/IMG_0003_draft.jpg":                     false,
+		"/watch/.picasa.ini":                            false,
+		"/watch/IMG_0004.jpg.part":                      false,
+		"/watch/IMG_0005 (alice's conflicted copy).jpg": false,
+		"/watch/notes.txt":                              false,
+	} {
+		if actual := filter.Matches(path); actual != expected {
+			t.Errorf("Expected %v to match: %v, got %v", path, expected, actual)
+		}
+	}
+
+	config.DefaultExcludes = false
+	config.Include = nil
+	config.Exclude = nil
+
+	filter, err = config.FileFilter()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !filter.Matches("/watch/.picasa.ini") {
+		t.Error("Expected .picasa.ini to match without the default excludes")
+	}
+
+	config.Exclude = []string{"re:("}
+
+	if _, err := config.FileFilter(); err == nil {
+		t.Error("Expected an invalid regex to fail")
+	}
+}
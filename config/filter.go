@@ -0,0 +1,140 @@
+package config
+
+import (
+	"github.com/juju/errors"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+//marks a pattern as a regular expression rather than a glob
+const REGEX_PREFIX = "re:"
+
+//temporary, sync and editor files which are never uploaded, unless default_excludes is false
+var DEFAULT_EXCLUDES = []string{
+	".*",
+	"~$*",
+	"*~",
+	"*.swp",
+	"*.tmp",
+	"*.part",
+	"*.partial",
+	"*.crdownload",
+	"*.download",
+	"*.!sync",
+	"Thumbs.db",
+	"desktop.ini",
+	"re:(?i)\\(.*conflicted copy.*\\)",
+}
+
+//decides which files are watched and processed, by their names
+type FileFilter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+func (config *Config) FileFilter() (*FileFilter, error) {
+	include, err := compilePatterns(config.Include)
+
+	if err != nil {
+		return nil, errors.Annotate(err, "include")
+	}
+
+	excludes := config.Exclude
+
+	if config.DefaultExcludes {
+		excludes = append(append([]string{}, DEFAULT_EXCLUDES...), excludes...)
+	}
+
+	exclude, err := compilePatterns(excludes)
+
+	if err != nil {
+		return nil, errors.Annotate(err, "exclude")
+	}
+
+	return &FileFilter{
+		include: include,
+		exclude: exclude,
+	}, nil
+}
+
+//whether the file at path should be processed; with no include patterns, every file not excluded is
+func (ff *FileFilter) Matches(path string) bool {
+	name := filepath.Base(path)
+
+	for _, re := range ff.exclude {
+		if re.MatchString(name) {
+			return false
+		}
+	}
+
+	if len(ff.include) == 0 {
+		return true
+	}
+
+	for _, re := range ff.include {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+//globs match the whole file name, regexes any part of it
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	rv := make([]*regexp.Regexp, 0, len(patterns))
+
+	for _, pattern := range patterns {
+		var expr string
+
+		if strings.HasPrefix(pattern, REGEX_PREFIX) {
+			expr = strings.TrimPrefix(pattern, REGEX_PREFIX)
+		} else {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return nil, errors.Annotatef(err, "Invalid glob %v", pattern)
+			}
+
+			expr = globToRegex(pattern)
+		}
+
+		re, err := regexp.Compile(expr)
+
+		if err != nil {
+			return nil, errors.Annotatef(err, "Invalid pattern %v", pattern)
+		}
+
+		rv = append(rv, re)
+	}
+
+	return rv, nil
+}
+
+//globs are matched case insensitively, as names differ in case between cameras and platforms
+func globToRegex(glob string) string {
+	expr := "(?i)^"
+
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			expr += ".*"
+		case '?':
+			expr += "."
+		case '[':
+			//character classes are written the same way, and filepath.Match has already checked they are closed
+			end := strings.IndexByte(glob[i:], ']')
+			expr += glob[i : i+end+1]
+			i += end
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+			}
+
+			expr += regexp.QuoteMeta(string(glob[i]))
+		default:
+			expr += regexp.QuoteMeta(string(c))
+		}
+	}
+
+	return expr + "$"
+}
@@ -0,0 +1,201 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/BurntSushi/toml"
+	"github.com/Sirupsen/logrus"
+	"github.com/juju/errors"
+	"gopkg.in/yaml.v2"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+//environment variables override top level settings, e.g. FLICKRUP_ARCHIVE_DIR; later prefixes win
+var EnvPrefixes = []string{"FLICKRUP_", "FLICKRDOWN_"}
+
+//the format is chosen by extension: .yaml/.yml, .toml, anything else is JSON
+func Format(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".toml":
+		return "toml"
+	default:
+		return "json"
+	}
+}
+
+//parses any supported format into the generic values that json would produce, so that every format shares the json keys
+func toMap(data []byte, format string) (map[string]interface{}, error) {
+	var generic interface{}
+
+	switch format {
+	case "yaml":
+		err := yaml.Unmarshal(data, &generic)
+
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+
+		generic, err = stringKeys(generic)
+
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+	case "toml":
+		table := make(map[string]interface{})
+
+		_, err := toml.Decode(string(data), &table)
+
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+
+		generic = table
+	default:
+		err := json.Unmarshal(data, &generic)
+
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+
+	if generic == nil {
+		return make(map[string]interface{}), nil
+	}
+
+	values, ok := generic.(map[string]interface{})
+
+	if !ok {
+		return nil, errors.New("Config must be a mapping of keys to values")
+	}
+
+	return values, nil
+}
+
+func stringKeys(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		rv := make(map[string]interface{}, len(v))
+
+		for key, item := range v {
+			s, ok := key.(string)
+
+			if !ok {
+				return nil, errors.Errorf("Key %v is not a string", key)
+			}
+
+			converted, err := stringKeys(item)
+
+			if err != nil {
+				return nil, err
+			}
+
+			rv[s] = converted
+		}
+
+		return rv, nil
+	case []interface{}:
+		for i, item := range v {
+			converted, err := stringKeys(item)
+
+			if err != nil {
+				return nil, err
+			}
+
+			v[i] = converted
+		}
+	}
+
+	return value, nil
+}
+
+func decodeStrict(data []byte, target interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(target)
+
+	if err != nil && strings.Contains(err.Error(), "unknown field") {
+		return errors.Errorf("Unknown key %v", strings.TrimPrefix(err.Error(), "json: unknown field "))
+	}
+
+	return err
+}
+
+//sets string, int and bool fields of target from the environment, named after their json keys
+func ApplyEnvironment(target interface{}, prefixes ...string) error {
+	value := reflect.ValueOf(target).Elem()
+
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		key := strings.Split(field.Tag.Get("json"), ",")[0]
+
+		if key == "" || key == "-" {
+			continue
+		}
+
+		for _, prefix := range prefixes {
+			name := prefix + strings.ToUpper(key)
+			names := []string{name}
+
+			//variables named after keys from before version 2 still work, but the new name wins
+			for from, to := range version2Renames {
+				if to == key {
+					names = []string{prefix + strings.ToUpper(from), name}
+				}
+			}
+
+			for _, envName := range names {
+				env, ok := os.LookupEnv(envName)
+
+				if !ok {
+					continue
+				}
+
+				if envName != name {
+					logrus.Warnf("%v is deprecated, use %v instead", envName, name)
+				}
+
+				err := setFromString(value.Field(i), env)
+
+				if err != nil {
+					return errors.Annotatef(err, "Invalid value for %v", envName)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func setFromString(field reflect.Value, s string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(s)
+	case reflect.Int:
+		i, err := strconv.Atoi(s)
+
+		if err != nil {
+			return errors.Trace(err)
+		}
+
+		field.SetInt(int64(i))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+
+		if err != nil {
+			return errors.Trace(err)
+		}
+
+		field.SetBool(b)
+	default:
+		return errors.Errorf("%v settings cannot be set from the environment", field.Kind())
+	}
+
+	return nil
+}
@@ -0,0 +1,168 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, dir string, name string, content string) string {
+	path := dir + "/" + name
+
+	err := ioutil.WriteFile(path, []byte(content), 0600)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestLoadFormats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	files := []string{
+		writeConfig(t, dir, "config.json", `{"version": 2, "api_key": "key", "shared_secret": "secret", "archive_dir": "`+dir+`", "tagset_prefix": "set:", "accounts": {"bob": {"token_file": "/tmp/bob"}}}`),
+		writeConfig(t, dir, "config.yaml", "version: 2\napi_key: key\nshared_secret: secret\narchive_dir: "+dir+"\ntagset_prefix: \"set:\"\naccounts:\n  bob:\n    token_file: /tmp/bob\n"),
+		writeConfig(t, dir, "config.toml", "version = 2\napi_key = \"key\"\nshared_secret = \"secret\"\narchive_dir = \""+dir+"\"\ntagset_prefix = \"set:\"\n[accounts.bob]\ntoken_file = \"/tmp/bob\"\n"),
+	}
+
+	for _, file := range files {
+		config, err := Load(file)
+
+		if err != nil {
+			t.Fatalf("Failed to load %v: %v", file, err)
+		}
+
+		assertEquals("set:", config.TagsetPrefix, t)
+		assertEquals("/tmp/bob", config.Accounts["bob"].TokenFile, t)
+	}
+}
+
+func TestUnknownKeysRejected(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	_, err = Read(writeConfig(t, dir, "config.yaml", "version: 2\napi_key: key\ntagset_prefx: \"set:\"\n"))
+
+	if err == nil || !strings.Contains(err.Error(), "tagset_prefx") {
+		t.Errorf("Expected unknown key error, got %v", err)
+	}
+}
+
+func TestEnvironmentOverrides(t *testing.T) {
+	os.Setenv("FLICKRUP_TAGSET_PREFIX", "up:")
+	os.Setenv("FLICKRDOWN_NEAR_DUPLICATE_DISTANCE", "12")
+	defer os.Unsetenv("FLICKRUP_TAGSET_PREFIX")
+	defer os.Unsetenv("FLICKRDOWN_NEAR_DUPLICATE_DISTANCE")
+
+	config := &Config{TagsetPrefix: "set:"}
+
+	err := ApplyEnvironment(config, EnvPrefixes...)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertEquals("up:", config.TagsetPrefix, t)
+
+	if config.NearDuplicateDistance != 12 {
+		t.Errorf("Expected distance 12, got %v", config.NearDuplicateDistance)
+	}
+}
+
+func TestDeprecatedEnvironmentNames(t *testing.T) {
+	os.Setenv("FLICKRUP_VISIBILITYPREFIX", "old:")
+	defer os.Unsetenv("FLICKRUP_VISIBILITYPREFIX")
+
+	config := &Config{}
+
+	err := ApplyEnvironment(config, EnvPrefixes...)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertEquals("old:", config.VisibilityPrefix, t)
+
+	os.Setenv("FLICKRUP_VISIBILITY_PREFIX", "new:")
+	defer os.Unsetenv("FLICKRUP_VISIBILITY_PREFIX")
+
+	err = ApplyEnvironment(config, EnvPrefixes...)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertEquals("new:", config.VisibilityPrefix, t)
+}
+
+func TestValidate(t *testing.T) {
+	config := &Config{SharedSecret: "secret", ArchiveDir: "/does/not/exist", NearDuplicates: "maybe"}
+
+	err := config.Validate()
+
+	if err == nil {
+		t.Fatal("Expected validation to fail")
+	}
+
+	for _, problem := range []string{"api_key is required", "archive_dir", "near_duplicates"} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("Expected '%v' in %v", problem, err)
+		}
+	}
+}
+
+func TestMigrateVersion1(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	config, err := Read(writeConfig(t, dir, "config.json", `{"tagsetprefix": "set:", "visibilityprefix": "vis:"}`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertEquals("set:", config.TagsetPrefix, t)
+	assertEquals("vis:", config.VisibilityPrefix, t)
+
+	if config.Version != CURRENT_VERSION || config.NearDuplicateDistance != 10 {
+		t.Errorf("Expected defaults to be applied, got %+v", config)
+	}
+
+	_, err = Read(writeConfig(t, dir, "clash.json", `{"tagsetprefix": "set:", "tagset_prefix": "set:"}`))
+
+	if err == nil {
+		t.Error("Expected old and new keys together to fail")
+	}
+}
+
+func TestMapDropboxPath(t *testing.T) {
+	ts := &TransferService{
+		DropboxDirMapping: map[string]string{
+			"/mnt/photos":        "/home/me/Dropbox/Photos",
+			"/mnt/photos/camera": "/home/me/Dropbox/Camera Uploads",
+		},
+	}
+
+	assertEquals("/home/me/Dropbox/Photos/2016/a.jpg", ts.MapDropboxPath("/mnt/photos/2016/a.jpg"), t)
+	assertEquals("/home/me/Dropbox/Camera Uploads/b.jpg", ts.MapDropboxPath("/mnt/photos/camera/b.jpg"), t)
+	assertEquals("/mnt/photoshop/c.jpg", ts.MapDropboxPath("/mnt/photoshop/c.jpg"), t)
+}
@@ -0,0 +1,94 @@
+package config
+
+import (
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/juju/errors"
+	"strings"
+)
+
+//files without a version are version 1
+const CURRENT_VERSION = 2
+
+//each migration upgrades the raw values of a config file from the version before it
+var migrations = map[int]func(values map[string]interface{}) error{
+	2: renameKeys(version2Renames),
+}
+
+var version2Renames = map[string]string{
+	"tagsetprefix":     "tagset_prefix",
+	"visibilityprefix": "visibility_prefix",
+	"statefile":        "state_file",
+}
+
+func migrate(path string, values map[string]interface{}) error {
+	version := 1
+
+	if v, ok := values["version"]; ok {
+		f, isNumber := v.(float64)
+
+		if !isNumber {
+			if i, isInt := v.(int); isInt {
+				f, isNumber = float64(i), true
+			} else if i, isInt64 := v.(int64); isInt64 {
+				f, isNumber = float64(i), true
+			}
+		}
+
+		if !isNumber || f != float64(int(f)) {
+			return errors.Errorf("Invalid version %v in %v", v, path)
+		}
+
+		version = int(f)
+	}
+
+	if version > CURRENT_VERSION {
+		return errors.Errorf("%v is config version %v, but only versions up to %v are supported", path, version, CURRENT_VERSION)
+	}
+
+	if version == CURRENT_VERSION {
+		return nil
+	}
+
+	for v := version + 1; v <= CURRENT_VERSION; v++ {
+		err := migrations[v](values)
+
+		if err != nil {
+			return errors.Annotatef(err, "Failed to migrate %v to version %v", path, v)
+		}
+	}
+
+	logrus.Warnf("%v is config version %v; it has been upgraded in memory, run 'config check' to see the version %v settings", path, version, CURRENT_VERSION)
+
+	values["version"] = CURRENT_VERSION
+
+	return nil
+}
+
+func renameKeys(renames map[string]string) func(values map[string]interface{}) error {
+	return func(values map[string]interface{}) error {
+		clashes := make([]string, 0)
+
+		for from, to := range renames {
+			value, ok := values[from]
+
+			if !ok {
+				continue
+			}
+
+			if _, exists := values[to]; exists {
+				clashes = append(clashes, fmt.Sprintf("%v and %v", from, to))
+				continue
+			}
+
+			values[to] = value
+			delete(values, from)
+		}
+
+		if len(clashes) > 0 {
+			return errors.Errorf("Both old and new keys are set: %v", strings.Join(clashes, ", "))
+		}
+
+		return nil
+	}
+}
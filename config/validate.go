@@ -0,0 +1,279 @@
+package config
+
+import (
+	"fmt"
+	"github.com/juju/errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//checks required settings and that configured paths exist, reporting every problem at once
+func (config *Config) Validate() error {
+	problems := make([]string, 0)
+
+	require := func(key string, value string, fromAccount func(*Account) string) {
+		if value != "" {
+			return
+		}
+
+		if len(config.Accounts) == 0 {
+			problems = append(problems, key+" is required")
+			return
+		}
+
+		for _, name := range config.AccountNames() {
+			if fromAccount(config.Accounts[name]) == "" {
+				problems = append(problems, key+" is required, at the top level or for account "+name)
+			}
+		}
+	}
+
+	checkVisibility := func(key string, visibility string) {
+		switch visibility {
+		case "", "default", "offline", "family", "friends", "private", "public":
+		default:
+			problems = append(problems, key+" must be default, offline, family, friends, private or public")
+		}
+	}
+
+	require("api_key", config.APIKey, func(a *Account) string { return a.APIKey })
+	require("shared_secret", config.SharedSecret, func(a *Account) string { return a.SharedSecret })
+	require("archive_dir", config.ArchiveDir, func(a *Account) string { return a.ArchiveDir })
+
+	checkDir := func(key string, path string) {
+		if path == "" {
+			return
+		}
+
+		info, err := os.Stat(os.ExpandEnv(path))
+
+		if err != nil {
+			problems = append(problems, key+": "+err.Error())
+		} else if !info.IsDir() {
+			problems = append(problems, key+": "+path+" is not a directory")
+		}
+	}
+
+	checkParent := func(key string, path string) {
+		if path != "" {
+			checkDir(key, filepath.Dir(os.ExpandEnv(path)))
+		}
+	}
+
+	checkDir("archive_dir", config.ArchiveDir)
+	checkDir("watch_dir", config.WatchDir)
+
+	for i, wd := range config.WatchDirs {
+		key := fmt.Sprintf("watch_dirs[%v]", i)
+
+		if wd == nil || wd.Dir == "" {
+			problems = append(problems, key+".dir is required")
+			continue
+		}
+
+		checkDir(key+".dir", wd.Dir)
+		checkDir(key+".archive_dir", wd.ArchiveDir)
+		checkVisibility(key+".untagged_visibility", wd.UntaggedVisibility)
+	}
+
+	roots := config.WatchRoots()
+
+	for i := range roots {
+		for j := i + 1; j < len(roots); j++ {
+			switch {
+			case filepath.Clean(roots[i]) == filepath.Clean(roots[j]):
+				problems = append(problems, "watch dir "+roots[i]+" is listed more than once")
+			case config.WatchRecursive && (within(roots[i], roots[j]) || within(roots[j], roots[i])):
+				problems = append(problems, "watch dirs "+roots[i]+" and "+roots[j]+" overlap")
+			}
+		}
+	}
+
+	switch config.Watching() {
+	case "auto", "notify", "poll":
+	default:
+		problems = append(problems, "watch_mode must be auto, notify or poll")
+	}
+
+	if _, err := config.PollInterval(); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	for stage := range config.StageTimeouts {
+		switch stage {
+		case "convert", "upload", "sets", "archive":
+			if _, err := config.StageTimeout(stage); err != nil {
+				problems = append(problems, err.Error())
+			}
+		default:
+			problems = append(problems, "stage_timeouts."+stage+" is not a stage; use convert, upload, sets or archive")
+		}
+	}
+
+	if _, err := config.FileFilter(); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	checkVisibility("default_visibility", config.DefaultVisibility)
+	checkVisibility("untagged_visibility", config.UntaggedVisibility)
+	checkParent("index_file", config.IndexFile)
+	checkParent("journal_file", config.JournalFile)
+	checkParent("quarantine_dir", config.QuarantineDir)
+	checkParent("token_file", config.TokenFile)
+
+	for _, name := range config.AccountNames() {
+		account := config.Accounts[name]
+
+		if account == nil {
+			problems = append(problems, "account "+name+" has no settings")
+			continue
+		}
+
+		checkDir("accounts."+name+".archive_dir", account.ArchiveDir)
+		checkParent("accounts."+name+".token_file", account.TokenFile)
+	}
+
+	if _, err := config.Location(); err != nil {
+		problems = append(problems, "timezone: "+err.Error())
+	}
+
+	if _, err := config.Schedule(); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	if _, err := config.Lookback(); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	switch config.NearDuplicates {
+	case "", "warn", "block":
+	default:
+		problems = append(problems, "near_duplicates must be warn or block")
+	}
+
+	if _, _, err := config.Stability(); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	switch config.Policy() {
+	case "strict", "skip_untagged", "per_folder":
+	case "deadline":
+		if _, err := config.Deadline(); err != nil {
+			problems = append(problems, err.Error())
+		}
+
+		if len(config.DefaultTags) == 0 && config.DefaultVisibility == "" {
+			problems = append(problems, "default_tags or default_visibility is required for the deadline selection policy")
+		}
+	default:
+		problems = append(problems, "selection_policy must be strict, skip_untagged, per_folder or deadline")
+	}
+
+	if config.DefaultVisibility != "" && config.VisibilityPrefix == "" {
+		problems = append(problems, "default_visibility requires a visibility_prefix")
+	}
+
+	switch config.DuplicateUploads {
+	case "", "skip", "attach":
+	default:
+		problems = append(problems, "duplicate_uploads must be skip or attach")
+	}
+
+	if config.NearDuplicateDistance < 0 || config.NearDuplicateDistance > 64 {
+		problems = append(problems, "near_duplicate_distance must be between 0 and 64")
+	}
+
+	switch config.TokenStoreType() {
+	case "file", "encrypted":
+	case "command":
+		if len(config.TokenCommand()) == 0 {
+			problems = append(problems, "token_store.command is required for the command token store")
+		}
+	default:
+		problems = append(problems, "token_store.type must be file, encrypted or command")
+	}
+
+	for tagName, replacements := range config.TagReplacements {
+		for present := range replacements {
+			if !strings.HasPrefix(present, "$") {
+				problems = append(problems, "tag_replacements."+tagName+": "+present+" must be a $keyword, static replacements are not supported")
+			}
+		}
+	}
+
+	for ext, command := range config.ConvertFiles {
+		if ext != strings.ToLower(ext) || strings.HasPrefix(ext, ".") {
+			problems = append(problems, "convert_files."+ext+": extensions must be lower case without a leading dot")
+		}
+
+		if len(command) == 0 {
+			problems = append(problems, "convert_files."+ext+": command is empty")
+		}
+
+		for _, arg := range command {
+			os.Expand(arg, func(k string) string {
+				if k != "file" {
+					problems = append(problems, "convert_files."+ext+": only ${file} can be expanded, not ${"+k+"}")
+				}
+				return ""
+			})
+		}
+	}
+
+	if config.TransferService != nil {
+		for from := range config.TransferService.DropboxDirMapping {
+			checkDir("transfer_service.dropbox_dir_mapping", from)
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.Errorf("Invalid config:\n  %v", strings.Join(problems, "\n  "))
+	}
+
+	return nil
+}
+
+//returns a copy with secrets replaced, for display
+func (config *Config) Masked() *Config {
+	rv := *config
+	rv.APIKey = mask(rv.APIKey)
+	rv.SharedSecret = mask(rv.SharedSecret)
+
+	if config.TransferService != nil {
+		transfer := *config.TransferService
+		transfer.Password = mask(transfer.Password)
+		rv.TransferService = &transfer
+	}
+
+	if config.Accounts != nil {
+		rv.Accounts = make(map[string]*Account, len(config.Accounts))
+
+		for name, account := range config.Accounts {
+			if account == nil {
+				continue
+			}
+
+			masked := *account
+			masked.APIKey = mask(masked.APIKey)
+			masked.SharedSecret = mask(masked.SharedSecret)
+			rv.Accounts[name] = &masked
+		}
+	}
+
+	return &rv
+}
+
+func mask(secret string) string {
+	if secret == "" {
+		return ""
+	}
+
+	return "****"
+}
+
+//whether dir is root or inside it
+func within(dir string, root string) bool {
+	rel, err := filepath.Rel(filepath.Clean(root), filepath.Clean(dir))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	flickrdownconfig "github.com/jpg0/flickrdown/config"
+	"github.com/juju/errors"
+	"github.com/rickb777/date"
+	"github.com/urfave/cli"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var sincePattern = regexp.MustCompile(`^(\d+)([dwmy])$`)
+
+//the ways of choosing which days to work on; at most one may be given
+type DateArgs struct {
+	Start     string
+	End       string
+	Since     string
+	Month     string
+	Year      string
+	Today     bool
+	Yesterday bool
+}
+
+func dateArgsFrom(c *cli.Context) DateArgs {
+	return DateArgs{
+		Start:     c.GlobalString("startdate"),
+		End:       c.GlobalString("enddate"),
+		Since:     c.GlobalString("since"),
+		Month:     c.GlobalString("month"),
+		Year:      c.GlobalString("year"),
+		Today:     c.GlobalBool("today"),
+		Yesterday: c.GlobalBool("yesterday"),
+	}
+}
+
+//returns the days from start (inclusive) to end (exclusive), relative to today in the configured timezone.
+//A start with no end is that one day, as it always has been; defaultStart is used when no range is given.
+func (args DateArgs) Resolve(today date.Date, defaultStart date.Date) (date.Date, date.Date, error) {
+	given := make([]string, 0)
+
+	if args.Start != "" || args.End != "" {
+		given = append(given, "--startdate/--enddate")
+	}
+
+	for name, value := range map[string]bool{"--since": args.Since != "", "--month": args.Month != "", "--year": args.Year != "", "--today": args.Today, "--yesterday": args.Yesterday} {
+		if value {
+			given = append(given, name)
+		}
+	}
+
+	if len(given) > 1 {
+		return date.Date{}, date.Date{}, errors.Errorf("Only one date range can be given, but got %v", strings.Join(given, ", "))
+	}
+
+	untilNow := today.Add(1)
+
+	switch {
+	case args.Today:
+		return today, untilNow, nil
+	case args.Yesterday:
+		return today.Add(-1), today, nil
+	case args.Since != "":
+		match := sincePattern.FindStringSubmatch(args.Since)
+
+		if match == nil {
+			return date.Date{}, date.Date{}, errors.Errorf("Invalid --since %v, expected a number of days, weeks, months or years such as 7d, 2w, 3m or 1y", args.Since)
+		}
+
+		n, _ := strconv.Atoi(match[1])
+
+		switch match[2] {
+		case "d":
+			return today.Add(date.PeriodOfDays(-n)), untilNow, nil
+		case "w":
+			return today.Add(date.PeriodOfDays(-7 * n)), untilNow, nil
+		case "m":
+			return today.AddDate(0, -n, 0), untilNow, nil
+		default:
+			return today.AddDate(-n, 0, 0), untilNow, nil
+		}
+	case args.Month != "":
+		start, err := time.Parse("2006-01", args.Month)
+
+		if err != nil {
+			return date.Date{}, date.Date{}, errors.Errorf("Invalid --month %v, expected yyyy-mm", args.Month)
+		}
+
+		first := date.New(start.Year(), start.Month(), 1)
+
+		return first, first.AddDate(0, 1, 0), nil
+	case args.Year != "":
+		year, err := strconv.Atoi(args.Year)
+
+		if err != nil || len(args.Year) != 4 {
+			return date.Date{}, date.Date{}, errors.Errorf("Invalid --year %v, expected yyyy", args.Year)
+		}
+
+		return date.New(year, time.January, 1), date.New(year+1, time.January, 1), nil
+	}
+
+	start := defaultStart
+	end := untilNow
+
+	if args.Start != "" {
+		d, err := date.Parse(input_layout, args.Start)
+
+		if err != nil {
+			return date.Date{}, date.Date{}, errors.Annotatef(err, "Invalid --startdate %v", args.Start)
+		}
+
+		start = d
+
+		if args.End == "" {
+			end = start.Add(1)
+		}
+	}
+
+	if args.End != "" {
+		d, err := date.Parse(input_layout, args.End)
+
+		if err != nil {
+			return date.Date{}, date.Date{}, errors.Annotatef(err, "Invalid --enddate %v", args.End)
+		}
+
+		end = d
+	}
+
+	if start.IsZero() {
+		return date.Date{}, date.Date{}, errors.New("No date range given, use --since, --month, --year, --today, --yesterday or --startdate")
+	}
+
+	if end.Before(start) {
+		return date.Date{}, date.Date{}, errors.Errorf("End date %v is before start date %v", end, start)
+	}
+
+	return start, end, nil
+}
+
+//resolves the date arguments for a config, in its timezone
+func dateRange(c *cli.Context, config *flickrdownconfig.Config, defaultStart date.Date) (date.Date, date.Date, *time.Location, error) {
+	loc, err := config.Location()
+
+	if err != nil {
+		return date.Date{}, date.Date{}, nil, errors.Trace(err)
+	}
+
+	start, end, err := dateArgsFrom(c).Resolve(date.TodayIn(loc), defaultStart)
+
+	return start, end, loc, err
+}
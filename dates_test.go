@@ -0,0 +1,40 @@
+package main
+
+import (
+	"github.com/rickb777/date"
+	"testing"
+	"time"
+)
+
+var today = date.New(2016, time.March, 10)
+
+func assertRange(args DateArgs, expectedStart date.Date, expectedEnd date.Date, t *testing.T) {
+	start, end, err := args.Resolve(today, date.Date{})
+
+	if err != nil {
+		t.Fatalf("Failed to resolve %+v: %v", args, err)
+	}
+
+	if start != expectedStart || end != expectedEnd {
+		t.Errorf("Expected %v to %v for %+v, got %v to %v", expectedStart, expectedEnd, args, start, end)
+	}
+}
+
+func TestResolveDateRange(t *testing.T) {
+	assertRange(DateArgs{Today: true}, today, today.Add(1), t)
+	assertRange(DateArgs{Yesterday: true}, today.Add(-1), today, t)
+	assertRange(DateArgs{Since: "7d"}, date.New(2016, time.March, 3), today.Add(1), t)
+	assertRange(DateArgs{Since: "1m"}, date.New(2016, time.February, 10), today.Add(1), t)
+	assertRange(DateArgs{Month: "2016-02"}, date.New(2016, time.February, 1), date.New(2016, time.March, 1), t)
+	assertRange(DateArgs{Year: "2015"}, date.New(2015, time.January, 1), date.New(2016, time.January, 1), t)
+	assertRange(DateArgs{Start: "2016-01-01"}, date.New(2016, time.January, 1), date.New(2016, time.January, 2), t)
+	assertRange(DateArgs{Start: "2016-01-01", End: "2016-01-02"}, date.New(2016, time.January, 1), date.New(2016, time.January, 2), t)
+}
+
+func TestInvalidDateRanges(t *testing.T) {
+	for _, args := range []DateArgs{{}, {Today: true, Month: "2016-01"}, {Since: "7"}, {Month: "2016"}, {Year: "16"}, {Start: "2016-02-01", End: "2016-01-01"}} {
+		if _, _, err := args.Resolve(today, date.Date{}); err == nil {
+			t.Errorf("Expected %+v to fail", args)
+		}
+	}
+}
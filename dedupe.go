@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/jpg0/flickrdown/config"
+	"github.com/jpg0/flickrup/filetype"
+	"github.com/jpg0/flickrup/index"
+	"github.com/juju/errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	DEDUPE_REPORT     = "report"
+	DEDUPE_HARDLINK   = index.DECISION_HARDLINK
+	DEDUPE_QUARANTINE = index.DECISION_QUARANTINE
+)
+
+type DuplicateGroup struct {
+	Checksum string
+	Keep     string
+	Others   []string
+}
+
+func Dedupe(config *config.Config, idx *index.Index, action string) error {
+
+	switch action {
+	case DEDUPE_REPORT, DEDUPE_HARDLINK, DEDUPE_QUARANTINE:
+	default:
+		return errors.Errorf("Unknown dedupe action: %v", action)
+	}
+
+	groups, err := FindDuplicates(config.ArchiveDir, idx)
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	count := 0
+
+	for _, group := range groups {
+		fmt.Printf("%v\n  keep %v\n", group.Checksum, group.Keep)
+
+		for _, other := range group.Others {
+			fmt.Printf("  dupe %v\n", other)
+
+			if action == DEDUPE_REPORT {
+				continue
+			}
+
+			err = resolveDuplicate(config, idx, group, other, action)
+
+			if err != nil {
+				logrus.Errorf("Failed to %v %v: %v", action, other, err)
+			} else {
+				count++
+			}
+		}
+	}
+
+	fmt.Printf("\nFound %v groups of duplicates, resolved %v files with %v\n", len(groups), count, action)
+
+	return nil
+}
+
+//hashes any files not already in the index
+func FindDuplicates(archiveDir string, idx *index.Index) ([]*DuplicateGroup, error) {
+
+	known := make(map[string]*index.Item)
+
+	err := idx.ForEach(func(item *index.Item) error {
+		known[item.Path] = item
+		return nil
+	})
+
+	if err != nil {
+		return nil, errors.Annotate(err, "Failed to read index")
+	}
+
+	byChecksum := make(map[string][]string)
+	inodes := make(map[string]os.FileInfo)
+
+	err = filepath.Walk(archiveDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if strings.HasPrefix(info.Name(), ".") && path != archiveDir {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.IsDir() || filepath.Ext(path) == ".meta" {
+			return nil
+		}
+
+		item := known[path]
+
+		if item == nil || item.Checksum == "" {
+			checksum, err := index.HashFile(path)
+
+			if err != nil {
+				return err
+			}
+
+			if item == nil {
+				item = &index.Item{Path: path}
+			}
+
+			item.Checksum = checksum
+
+			err = idx.Put(item)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		//files already hardlinked together are not duplicates
+		for _, other := range byChecksum[item.Checksum] {
+			if os.SameFile(inodes[other], info) {
+				return nil
+			}
+		}
+
+		byChecksum[item.Checksum] = append(byChecksum[item.Checksum], path)
+		inodes[path] = info
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, errors.Annotate(err, "Failed to scan archive")
+	}
+
+	groups := make([]*DuplicateGroup, 0)
+
+	for checksum, paths := range byChecksum {
+		if len(paths) < 2 {
+			continue
+		}
+
+		sort.Sort(byPreference{paths, known})
+
+		groups = append(groups, &DuplicateGroup{
+			Checksum: checksum,
+			Keep:     paths[0],
+			Others:   paths[1:],
+		})
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Keep < groups[j].Keep
+	})
+
+	return groups, nil
+}
+
+//prefer files known to Flickr, then the shortest path
+type byPreference struct {
+	paths []string
+	known map[string]*index.Item
+}
+
+func (a byPreference) Len() int {
+	return len(a.paths)
+}
+func (a byPreference) Swap(i, j int) {
+	a.paths[i], a.paths[j] = a.paths[j], a.paths[i]
+}
+func (a byPreference) Less(i, j int) bool {
+	iKnown := a.known[a.paths[i]] != nil && a.known[a.paths[i]].PhotoID != ""
+	jKnown := a.known[a.paths[j]] != nil && a.known[a.paths[j]].PhotoID != ""
+
+	if iKnown != jKnown {
+		return iKnown
+	}
+
+	if len(a.paths[i]) != len(a.paths[j]) {
+		return len(a.paths[i]) < len(a.paths[j])
+	}
+
+	return a.paths[i] < a.paths[j]
+}
+
+func resolveDuplicate(config *config.Config, idx *index.Index, group *DuplicateGroup, path string, action string) error {
+	var err error
+
+	switch action {
+	case DEDUPE_HARDLINK:
+		err = hardlink(group.Keep, path)
+	case DEDUPE_QUARANTINE:
+		err = quarantine(config, path)
+
+		if err == nil {
+			err = idx.Remove(path)
+		}
+	}
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	return idx.RecordDecision(&index.Decision{
+		Path:     path,
+		KeptPath: group.Keep,
+		Checksum: group.Checksum,
+		Action:   action,
+		Time:     time.Now(),
+	})
+}
+
+//replaces path with a link to keep, without leaving a window in which path is missing
+func hardlink(keep string, path string) error {
+	tmp := path + ".dedupe"
+
+	err := os.Link(keep, tmp)
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	err = os.Rename(tmp, path)
+
+	if err != nil {
+		os.Remove(tmp)
+		return errors.Trace(err)
+	}
+
+	return nil
+}
+
+//moves the file, and its .meta file if any, keeping its path relative to the archive
+func quarantine(config *config.Config, path string) error {
+	rel, err := filepath.Rel(config.ArchiveDir, path)
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	target := filepath.Join(config.QuarantinePath(), rel)
+
+	err = os.MkdirAll(filepath.Dir(target), 0755)
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	err = filetype.MoveFile(path, target)
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	logrus.Infof("Quarantined %v as %v", path, target)
+
+	meta := strings.TrimSuffix(path, filepath.Ext(path)) + ".meta"
+
+	if _, err := os.Stat(meta); err == nil {
+		return filetype.MoveFile(meta, strings.TrimSuffix(target, filepath.Ext(target)) + ".meta")
+	}
+
+	return nil
+}
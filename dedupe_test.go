@@ -0,0 +1,87 @@
+package main
+
+import (
+	"github.com/jpg0/flickrdown/config"
+	"github.com/jpg0/flickrup/index"
+	"os"
+	"testing"
+)
+
+func TestDedupeHardlinksDuplicates(t *testing.T) {
+	dir := writeArchive(t, map[string]string{
+		"a.jpg":  "same",
+		"bb.jpg": "same",
+		"c.jpg":  "different",
+	})
+	defer os.RemoveAll(dir)
+
+	idx, err := index.New(dir + "/.index.db")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	groups, err := FindDuplicates(dir, idx)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(groups) != 1 || len(groups[0].Others) != 1 {
+		t.Fatalf("Expected one pair of duplicates, got %v", groups)
+	}
+
+	assertEquals(dir+"/a.jpg", groups[0].Keep, t)
+
+	err = Dedupe(&config.Config{ArchiveDir: dir}, idx, DEDUPE_HARDLINK)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, _ := os.Stat(dir + "/a.jpg")
+	bb, _ := os.Stat(dir + "/bb.jpg")
+
+	if !os.SameFile(a, bb) {
+		t.Error("Expected duplicate to be hardlinked")
+	}
+
+	decision, err := idx.DecisionFor(dir + "/bb.jpg")
+
+	if err != nil || decision == nil {
+		t.Errorf("Expected decision to be recorded, got %v (%v)", decision, err)
+	}
+
+	groups, _ = FindDuplicates(dir, idx)
+
+	if len(groups) != 0 {
+		t.Errorf("Expected no duplicates after hardlinking, got %v", groups)
+	}
+}
+
+func TestDedupeQuarantine(t *testing.T) {
+	dir := writeArchive(t, map[string]string{
+		"a.jpg":   "same",
+		"bb.jpg":  "same",
+		"bb.meta": testMeta,
+	})
+	defer os.RemoveAll(dir)
+
+	idx, err := index.New(dir + "/.index.db")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Dedupe(&config.Config{ArchiveDir: dir}, idx, DEDUPE_QUARANTINE)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, moved := range []string{"bb.jpg", "bb.meta"} {
+		if _, err := os.Stat(dir + "/.quarantine/" + moved); err != nil {
+			t.Errorf("Expected %v to be quarantined: %v", moved, err)
+		}
+	}
+}
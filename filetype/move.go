@@ -5,9 +5,19 @@ import (
 	"github.com/Sirupsen/logrus"
 	"io"
 	"github.com/juju/errors"
+	"golang.org/x/net/context"
 )
 
 func MoveFile(from string, to string) error {
+	return MoveFileContext(context.Background(), from, to)
+}
+
+//as MoveFile, but a copy across filesystems is abandoned once ctx is done, leaving the original in place
+func MoveFileContext(ctx context.Context, from string, to string) error {
+	if err := ctx.Err(); err != nil {
+		return errors.Annotatef(err, "Not moving %v", from)
+	}
+
 	err := os.Rename(from, to)
 
 	if err == nil {
@@ -17,16 +27,17 @@ func MoveFile(from string, to string) error {
 	logrus.Debugf("Failed to move file: %v", err)
 	logrus.Debugf("Falling back to copy...")
 
-	err = copyFileContents(from, to)
+	err = copyFileContents(ctx, from, to)
 
 	if err != nil {
+		os.Remove(to)
 		return errors.Annotate(err, "Failed to copy file for archive")
 	}
 
 	return os.Remove(from)
 }
 
-func copyFileContents(src, dst string) (err error) {
+func copyFileContents(ctx context.Context, src, dst string) (err error) {
 	in, err := os.Open(src)
 	if err != nil {
 		return err
@@ -42,9 +53,23 @@ func copyFileContents(src, dst string) (err error) {
 			err = cerr
 		}
 	}()
-	if _, err = io.Copy(out, in); err != nil {
+	if _, err = io.Copy(out, &contextReader{ctx, in}); err != nil {
 		return err
 	}
 	err = out.Sync()
 	return nil
 }
+
+//fails reads once ctx is done, so that long copies can be abandoned
+type contextReader struct {
+	ctx context.Context
+	reader io.Reader
+}
+
+func (cr *contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return cr.reader.Read(p)
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/juju/errors"
 	"github.com/Sirupsen/logrus"
 	"strings"
+	"golang.org/x/net/context"
 )
 
 func VideoConversionStage() func(ctx *processing.PreprocessingContext, next processing.Preprocessor) processing.ProcessingResult {
@@ -25,12 +26,12 @@ func VideoConversionStage() func(ctx *processing.PreprocessingContext, next proc
 
 			logrus.Infof("Converting file %s", ctx.Filepath)
 
-			out, err := convert(conversionCmd, ctx.Filepath)
+			out, err := convert(ctx.Context, conversionCmd, ctx.Filepath)
 
 			if err == nil {
 				ctx.RequiresRestart = true
 
-				err = MoveFile(ctx.Filepath, os.TempDir() + "/" + filepath.Base(ctx.Filepath))
+				err = MoveFileContext(ctx.Context, ctx.Filepath, os.TempDir() + "/" + filepath.Base(ctx.Filepath))
 
 				if err != nil {
 					logrus.Errorf("Failed to move converted file: %s", err)
@@ -48,7 +49,8 @@ func VideoConversionStage() func(ctx *processing.PreprocessingContext, next proc
 	}
 }
 
-func convert(conversionCmd []string, filepath string) (string, error) {
+//the command is killed once ctx is done
+func convert(ctx context.Context, conversionCmd []string, filepath string) (string, error) {
 
 	expand := func(k string) string {
 		if k != "file" {
@@ -64,6 +66,6 @@ func convert(conversionCmd []string, filepath string) (string, error) {
 		formatted[i] = os.Expand(conversionCmd[i + 1], expand)
 	}
 
-	cmdOut, err := exec.Command(conversionCmd[0], formatted...).CombinedOutput()
+	cmdOut, err := exec.CommandContext(ctx, conversionCmd[0], formatted...).CombinedOutput()
 	return string(cmdOut), err
 }
\ No newline at end of file
@@ -2,81 +2,294 @@ package flickraccess
 
 import (
 	"io/ioutil"
-	"gopkg.in/yaml.v2"
 	"fmt"
 	"bufio"
 	"os"
 	"strings"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+	"golang.org/x/crypto/ssh/terminal"
 	"github.com/juju/errors"
 	"github.com/jpg0/flickr"
+	log "github.com/Sirupsen/logrus"
 )
 
-func getToken(client *flickr.FlickrClient) (*flickr.OAuthToken, error) {
-	filepath := os.ExpandEnv("${HOME}/.flickrup")
+const DEFAULT_LOGIN_LISTEN = "127.0.0.1:0"
+const DEFAULT_LOGIN_PERMS = "delete"
+const DEFAULT_LOGIN_TIMEOUT = 5 * time.Minute
 
-	if _, err := os.Stat(filepath); os.IsNotExist(err) {
-		token, err := requestToken(client)
+//flickr reports this code when a token has been revoked or is malformed
+const invalidTokenErrorCode = 98
 
-		if err != nil {
-			return nil, errors.Trace(err)
-		}
+type LoginOptions struct {
+	Listen    string //address of the callback listener, must be reachable from the browser
+	OutOfBand bool   //skip the listener and paste the verifier instead
+	Perms     string //read, write or delete
+	Timeout   time.Duration
+}
 
-		bytes, err := yaml.Marshal(token)
+//the credentials attached to a stored token, as reported by flickr
+type TokenInfo struct {
+	Perms    string
+	Nsid     string
+	Username string
+	Fullname string
+}
 
-		err = ioutil.WriteFile(filepath, bytes, 0644)
+type checkTokenResponse struct {
+	flickr.BasicResponse
+	OAuth struct {
+		Token string `xml:"token"`
+		Perms string `xml:"perms"`
+		User  struct {
+			Nsid     string `xml:"nsid,attr"`
+			Username string `xml:"username,attr"`
+			Fullname string `xml:"fullname,attr"`
+		} `xml:"user"`
+	} `xml:"oauth"`
+}
 
-		if err != nil {
-			return nil, errors.Trace(err)
-		}
+func getToken(client *flickr.FlickrClient, store TokenStore) (*flickr.OAuthToken, error) {
+	token, err := store.Load()
+
+	if !errors.IsNotFound(err) {
+		return token, err
+	}
+
+	token, err = requestToken(client, DEFAULT_LOGIN_PERMS)
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	err = store.Save(token)
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return token, nil
+}
+
+func requestToken(client *flickr.FlickrClient, perms string) (*flickr.OAuthToken, error){
+	requestToken, err := flickr.GetRequestToken(client)
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	fmt.Println("Open this url in your process to complete the authentication process: ", authorizeUrl(requestToken, perms))
+	fmt.Println("Copy here the number given when you complete the process.")
+	reader := bufio.NewReader(os.Stdin)
+	text, _ := reader.ReadString('\n')
+
+	return flickr.GetAccessToken(client, requestToken, strings.TrimSpace(text))
+
+}
+
+//authorises a new token and stores it, replacing any existing token
+func Login(apiKey string, sharedSecret string, store TokenStore, opts LoginOptions) (*flickr.OAuthToken, error) {
+	client := flickr.NewFlickrClient(apiKey, sharedSecret)
 
-		return token, nil
+	var token *flickr.OAuthToken
+	var err error
 
+	if opts.OutOfBand {
+		token, err = requestToken(client, opts.Perms)
 	} else {
-		//load from file
+		token, err = requestTokenWithCallback(client, opts)
+	}
 
-		file, err := os.Open(filepath)
-		defer file.Close()
+	if err != nil {
+		return nil, errors.Annotate(err, "Failed to authorise")
+	}
 
-		if err != nil {
-			return nil, errors.Trace(err)
-		}
+	err = store.Save(token)
 
-		tokens := new(flickr.OAuthToken)
+	if err != nil {
+		return nil, errors.Annotatef(err, "Failed to store token in %v", store)
+	}
 
-		bytes, err := ioutil.ReadAll(file)
+	return token, nil
+}
 
-		if err != nil {
-			return nil, errors.Trace(err)
-		}
+//falls back to the out-of-band flow if the listener cannot be started
+func requestTokenWithCallback(client *flickr.FlickrClient, opts LoginOptions) (*flickr.OAuthToken, error) {
+	listen := opts.Listen
+
+	if listen == "" {
+		listen = DEFAULT_LOGIN_LISTEN
+	}
 
-		err = yaml.Unmarshal(bytes, tokens)
+	listener, err := net.Listen("tcp", listen)
 
-		if err != nil {
-			return nil, errors.Trace(err)
-		}
+	if err != nil {
+		log.Warnf("Cannot listen for the authorisation callback on %v, falling back to pasting the verifier: %v", listen, err)
+		return requestToken(client, opts.Perms)
+	}
+
+	defer listener.Close()
+
+	callback := fmt.Sprintf("http://%v/callback", listener.Addr())
+
+	reqToken, err := getRequestToken(client, callback)
+
+	if err != nil {
+		log.Warnf("Flickr rejected the callback %v, falling back to pasting the verifier: %v", callback, err)
+		//the failed request left callback arguments on the client
+		return requestToken(flickr.NewFlickrClient(client.ApiKey, client.ApiSecret), opts.Perms)
+	}
+
+	verifiers := make(chan string, 2)
 
-		return tokens, nil
+	go http.Serve(listener, callbackHandler(reqToken.OauthToken, verifiers))
+
+	fmt.Println("Open this url in your browser to complete the authentication process: ", authorizeUrl(reqToken, opts.Perms))
+
+	//left blocked on stdin if the callback wins, so only when someone is there to paste
+	if terminal.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Println("If the browser cannot reach", callback, "paste the oauth_verifier value from its address bar here.")
+
+		go func() {
+			text, err := bufio.NewReader(os.Stdin).ReadString('\n')
+
+			if err == nil && strings.TrimSpace(text) != "" {
+				sendVerifier(verifiers, strings.TrimSpace(text))
+			}
+		}()
+	}
+
+	timeout := opts.Timeout
+
+	if timeout == 0 {
+		timeout = DEFAULT_LOGIN_TIMEOUT
+	}
+
+	select {
+	case verifier := <-verifiers:
+		return flickr.GetAccessToken(client, reqToken, verifier)
+	case <-time.After(timeout):
+		return nil, errors.Errorf("Timed out after %v waiting for authorisation", timeout)
 	}
 }
 
-func requestToken(client *flickr.FlickrClient) (*flickr.OAuthToken, error){
-	requestToken, err := flickr.GetRequestToken(client)
+func getRequestToken(client *flickr.FlickrClient, callback string) (*flickr.RequestToken, error) {
+	client.Init()
+	client.EndpointUrl = flickr.REQUEST_TOKEN_URL
+	client.SetOAuthDefaults()
+	client.Args.Set("oauth_consumer_key", client.ApiKey)
+	client.Args.Set("oauth_callback", callback)
+	//there is no token secret yet
+	client.Sign("")
+
+	res, err := client.HTTPClient.Get(client.GetUrl())
 
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
 
-	url, err := flickr.GetAuthorizeUrl(client, requestToken)
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
 
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
 
-	fmt.Println("Open this url in your process to complete the authentication process: ", url)
-	fmt.Println("Copy here the number given when you complete the process.")
-	reader := bufio.NewReader(os.Stdin)
-	text, _ := reader.ReadString('\n')
+	requestToken, err := flickr.ParseRequestToken(string(body))
 
-	return flickr.GetAccessToken(client, requestToken, strings.TrimSpace(text))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if !requestToken.OauthCallbackConfirmed {
+		return nil, errors.New("Callback was not confirmed")
+	}
+
+	return requestToken, nil
+}
+
+func authorizeUrl(requestToken *flickr.RequestToken, perms string) string {
+	if perms == "" {
+		perms = DEFAULT_LOGIN_PERMS
+	}
+
+	args := url.Values{}
+	args.Set("oauth_token", requestToken.OauthToken)
+	args.Set("perms", perms)
+
+	return flickr.AUTHORIZE_URL + "?" + args.Encode()
+}
+
+func callbackHandler(oauthToken string, verifiers chan<- string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		verifier := query.Get("oauth_verifier")
+
+		if r.URL.Path != "/callback" || query.Get("oauth_token") != oauthToken || verifier == "" {
+			http.Error(w, "Unexpected authorisation callback", http.StatusBadRequest)
+			return
+		}
+
+		fmt.Fprintln(w, "Authorisation complete, you can close this window.")
+
+		sendVerifier(verifiers, verifier)
+	})
+}
+
+//only the first verifier is used, so later ones, such as from a reloaded callback page, are dropped
+func sendVerifier(verifiers chan<- string, verifier string) {
+	select {
+	case verifiers <- verifier:
+	default:
+	}
+}
+
+//returns a NotFound error if there is no stored token
+func CheckToken(apiKey string, sharedSecret string, store TokenStore) (*TokenInfo, error) {
+	token, err := store.Load()
+
+	if err != nil {
+		return nil, err
+	}
+
+	client := flickr.NewFlickrClient(apiKey, sharedSecret)
+	client.OAuthToken = token.OAuthToken
+	client.OAuthTokenSecret = token.OAuthTokenSecret
+
+	client.Init()
+	client.EndpointUrl = flickr.API_ENDPOINT
+	client.HTTPVerb = "POST"
+	client.Args.Set("method", "flickr.auth.oauth.checkToken")
+	client.Args.Set("oauth_token", token.OAuthToken)
+	client.OAuthSign()
+
+	response := &checkTokenResponse{}
+	err = flickr.DoPost(client, response)
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if response.HasErrors() {
+		if response.ErrorCode() == invalidTokenErrorCode {
+			return nil, errors.NotValidf("Token in %v (%v)", store, response.ErrorMsg())
+		}
+
+		return nil, errors.New(response.ErrorMsg())
+	}
+
+	return &TokenInfo{
+		Perms: response.OAuth.Perms,
+		Nsid: response.OAuth.User.Nsid,
+		Username: response.OAuth.User.Username,
+		Fullname: response.OAuth.User.Fullname,
+	}, nil
+}
 
+//flickr has no API to revoke a token, so this only forgets it locally
+func Logout(store TokenStore) error {
+	return store.Delete()
 }
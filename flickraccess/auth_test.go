@@ -0,0 +1,57 @@
+package flickraccess
+
+import (
+	"github.com/jpg0/flickr"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestCallbackHandlerAcceptsOnlyItsToken(t *testing.T) {
+	verifiers := make(chan string, 1)
+	handler := callbackHandler("request-token", verifiers)
+
+	for _, path := range []string{
+		"/callback?oauth_token=other-token&oauth_verifier=1234",
+		"/callback?oauth_token=request-token",
+		"/elsewhere?oauth_token=request-token&oauth_verifier=1234",
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected %v to be rejected, got %v", path, w.Code)
+		}
+	}
+
+	//a reloaded page must not block the handler once a verifier is waiting
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/callback?oauth_token=request-token&oauth_verifier=1234", nil))
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected the callback to be accepted, got %v", w.Code)
+		}
+	}
+
+	select {
+	case verifier := <-verifiers:
+		assertEquals("1234", verifier, t)
+	default:
+		t.Error("Expected the verifier to be passed on")
+	}
+}
+
+func TestAuthorizeUrlAsksForPerms(t *testing.T) {
+	for perms, expected := range map[string]string{"read": "read", "": DEFAULT_LOGIN_PERMS} {
+		u, err := url.Parse(authorizeUrl(&flickr.RequestToken{OauthToken: "request-token"}, perms))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assertEquals(expected, u.Query().Get("perms"), t)
+		assertEquals("request-token", u.Query().Get("oauth_token"), t)
+	}
+}
@@ -0,0 +1,92 @@
+package flickraccess
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"github.com/jpg0/flickr"
+	"github.com/jpg0/flickrup/config"
+	"github.com/juju/errors"
+	"io"
+	"os"
+)
+
+const CHECKSUM_TAG_PREFIX = "checksum:sha1="
+
+//finds photos which were uploaded with a checksum tag
+type ChecksumFinder interface {
+	//returns "" if there is no such photo
+	FindByChecksum(sha1 string) (string, error)
+}
+
+type FlickrChecksumFinder struct {
+	client *flickr.FlickrClient
+}
+
+func NewChecksumFinder(config *config.Config) (*FlickrChecksumFinder, error) {
+	store, err := NewTokenStore(config)
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	client := flickr.NewFlickrClient(config.APIKey, config.SharedSecret)
+	token, err := getToken(client, store)
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	client.OAuthToken = token.OAuthToken
+	client.OAuthTokenSecret = token.OAuthTokenSecret
+
+	return &FlickrChecksumFinder{client: client}, nil
+}
+
+func (finder *FlickrChecksumFinder) FindByChecksum(sha1 string) (string, error) {
+	client := finder.client
+	client.Init()
+	client.EndpointUrl = flickr.API_ENDPOINT
+	client.HTTPVerb = "POST"
+	client.Args.Set("method", "flickr.photos.search")
+	client.Args.Set("user_id", "me")
+	client.Args.Set("machine_tags", CHECKSUM_TAG_PREFIX+sha1)
+	client.OAuthSign()
+
+	response := &photoListResponse{}
+	err := flickr.DoPost(client, response)
+
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+
+	if response.HasErrors() {
+		return "", errors.New(response.ErrorMsg())
+	}
+
+	if len(response.Photos.Photos) == 0 {
+		return "", nil
+	}
+
+	return response.Photos.Photos[0].Id, nil
+}
+
+//the hex SHA-1 of the file's content, as used in checksum tags
+func SHA1File(path string) (string, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+
+	defer file.Close()
+
+	h := sha1.New()
+
+	_, err = io.Copy(h, file)
+
+	if err != nil {
+		return "", errors.Annotatef(err, "Failed to hash %v", path)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,39 @@
+package flickraccess
+
+import (
+	"github.com/jpg0/flickr"
+	"golang.org/x/net/context"
+	"net/http"
+)
+
+//a copy of the client whose requests are abandoned once ctx is done, as the flickr library takes no context
+func withContext(ctx context.Context, client *flickr.FlickrClient) *flickr.FlickrClient {
+	rv := *client
+
+	httpClient := http.DefaultClient
+
+	if client.HTTPClient != nil {
+		httpClient = client.HTTPClient
+	}
+
+	withCtx := *httpClient
+	withCtx.Transport = &contextTransport{ctx: ctx, transport: httpClient.Transport}
+	rv.HTTPClient = &withCtx
+
+	return &rv
+}
+
+type contextTransport struct {
+	ctx       context.Context
+	transport http.RoundTripper
+}
+
+func (ct *contextTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	transport := ct.transport
+
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	return transport.RoundTrip(request.WithContext(ct.ctx))
+}
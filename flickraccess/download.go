@@ -9,35 +9,72 @@ import (
 	"github.com/jpg0/flickrdown/config"
 	"github.com/juju/errors"
 	"github.com/rickb777/date"
+	"strconv"
+	"time"
 )
 
+type SearchSource interface {
+	Search(min_upload_date date.Date, max_upload_date date.Date) PhotoBatch
+	Photo(id string) RemotePhoto
+	UpdatedSince(since time.Time) PhotoBatch
+}
+
+type PhotoBatch interface {
+	NextPhoto() (RemotePhoto, error)
+}
+
+//provides the metadata for a single photo
+type RemotePhoto interface {
+	ID() string
+	GetMeta() (*Meta, error)
+	GetLocation() (*Location, error) //a separate request, so only made for photos being written
+}
+
 type FlickrDownloadClient struct {
 	apikey string
 	sharedsecret string
+	token *flickr.OAuthToken
+	location *time.Location //in which search days begin and end
 }
 
 func NewDownloadClient(config *config.Config) (*FlickrDownloadClient, error) {
+	store, err := NewTokenStore(config)
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	token, err := getToken(flickr.NewFlickrClient(config.APIKey, config.SharedSecret), store)
+
+	if err != nil {
+		return nil, errors.Annotate(err, "Failed to load token")
+	}
+
+	location, err := config.Location()
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
 	return &FlickrDownloadClient{
 		apikey: config.APIKey,
 		sharedsecret: config.SharedSecret,
+		token: token,
+		location: location,
 	}, nil
 }
 
+//flickr clients are not safe for concurrent use, so each request gets its own
 func (downloadclient *FlickrDownloadClient) newClient() *flickr.FlickrClient {
 	client := flickr.NewFlickrClient(downloadclient.apikey, downloadclient.sharedsecret)
-	token, err := getToken(client)
-
-	if err != nil { //lazy
-		panic("Failed to build flickr client")
-	}
 
-	client.OAuthToken = token.OAuthToken
-	client.OAuthTokenSecret = token.OAuthTokenSecret
+	client.OAuthToken = downloadclient.token.OAuthToken
+	client.OAuthTokenSecret = downloadclient.token.OAuthTokenSecret
 
 	return client
 }
 
-func (downloadclient *FlickrDownloadClient) Search(min_upload_date date.Date, max_upload_date date.Date) *DownloadBatch {
+func (downloadclient *FlickrDownloadClient) Search(min_upload_date date.Date, max_upload_date date.Date) PhotoBatch {
 
 	return &DownloadBatch{
 		from:   min_upload_date,
@@ -46,6 +83,14 @@ func (downloadclient *FlickrDownloadClient) Search(min_upload_date date.Date, ma
 	}
 }
 
+//a photo already known by ID, e.g. one which previously failed to download
+func (downloadclient *FlickrDownloadClient) Photo(id string) RemotePhoto {
+	return &FlickrRemotePhoto{
+		photoInfo: photos.PhotoInfo{Id: id},
+		client:    downloadclient.newClient(),
+	}
+}
+
 type DownloadBatch struct {
 	from     date.Date
 	to       date.Date
@@ -54,7 +99,7 @@ type DownloadBatch struct {
 	cursor   int
 }
 
-func (batch *DownloadBatch) NextPhoto() (*RemotePhoto, error) {
+func (batch *DownloadBatch) NextPhoto() (RemotePhoto, error) {
 
 
 	//if not yet fetched
@@ -62,7 +107,7 @@ func (batch *DownloadBatch) NextPhoto() (*RemotePhoto, error) {
 
 		logrus.Debugf("Searching for photos from %v to %v", batch.from, batch.to)
 
-		response, err := photos.Search(batch.client.newClient(), true, "me", batch.from.UTC(), batch.to.UTC(), 1)
+		response, err := photos.Search(batch.client.newClient(), true, "me", batch.from.In(batch.client.location), batch.to.In(batch.client.location), 1)
 
 		if err != nil {
 			return nil, errors.Annotate(err, "Failed to search for photos")
@@ -77,7 +122,7 @@ func (batch *DownloadBatch) NextPhoto() (*RemotePhoto, error) {
 	if batch.cursor == len(batch.response.PhotoList.Photos) {
 		//get next batch or error
 		if batch.response.PhotoList.Page < batch.response.PhotoList.Pages {
-			response, err := photos.Search(batch.client.newClient(), true, "me", batch.from.UTC(), batch.to.UTC(), batch.response.PhotoList.Page + 1)
+			response, err := photos.Search(batch.client.newClient(), true, "me", batch.from.In(batch.client.location), batch.to.In(batch.client.location), batch.response.PhotoList.Page + 1)
 
 			if err != nil {
 				return nil, errors.Annotate(err, "Failed to get next search page for photos")
@@ -93,23 +138,112 @@ func (batch *DownloadBatch) NextPhoto() (*RemotePhoto, error) {
 
 	batch.cursor++
 
-	return &RemotePhoto{
+	return &FlickrRemotePhoto{
 		photoInfo: batch.response.PhotoList.Photos[batch.cursor-1],
 		client:    batch.client.newClient(),
 	}, nil
 }
 
-type RemotePhoto struct {
+//photos whose metadata or content changed after since, newest uploads included
+func (downloadclient *FlickrDownloadClient) UpdatedSince(since time.Time) PhotoBatch {
+	return &UpdatedBatch{
+		since:  since,
+		client: downloadclient,
+	}
+}
+
+//the ids from a list of photos, as returned by searches
+type photoListResponse struct {
+	flickr.BasicResponse
+	Photos struct {
+		Page   int `xml:"page,attr"`
+		Pages  int `xml:"pages,attr"`
+		Photos []struct {
+			Id string `xml:"id,attr"`
+		} `xml:"photo"`
+	} `xml:"photos"`
+}
+
+type UpdatedBatch struct {
+	since    time.Time
+	response *photoListResponse
+	client   *FlickrDownloadClient
+	cursor   int
+}
+
+func (batch *UpdatedBatch) NextPhoto() (RemotePhoto, error) {
+
+	if batch.response == nil {
+		logrus.Debugf("Searching for photos updated since %v", batch.since)
+
+		response, err := recentlyUpdated(batch.client.newClient(), batch.since, 1)
+
+		if err != nil {
+			return nil, errors.Annotate(err, "Failed to search for updated photos")
+		}
+
+		batch.response = response
+	}
+
+	if batch.cursor == len(batch.response.Photos.Photos) {
+		if batch.response.Photos.Page >= batch.response.Photos.Pages {
+			return nil, nil
+		}
+
+		response, err := recentlyUpdated(batch.client.newClient(), batch.since, batch.response.Photos.Page + 1)
+
+		if err != nil {
+			return nil, errors.Annotate(err, "Failed to get next page of updated photos")
+		}
+
+		batch.response = response
+		batch.cursor = 0
+
+		if len(response.Photos.Photos) == 0 {
+			return nil, nil
+		}
+	}
+
+	batch.cursor++
+
+	return batch.client.Photo(batch.response.Photos.Photos[batch.cursor-1].Id), nil
+}
+
+func recentlyUpdated(client *flickr.FlickrClient, since time.Time, page int) (*photoListResponse, error) {
+	client.Init()
+	client.EndpointUrl = flickr.API_ENDPOINT
+	client.HTTPVerb = "POST"
+	client.Args.Set("method", "flickr.photos.recentlyUpdated")
+	client.Args.Set("min_date", strconv.FormatInt(since.Unix(), 10))
+	client.Args.Set("per_page", "500")
+	client.Args.Set("page", strconv.Itoa(page))
+	client.OAuthSign()
+
+	response := &photoListResponse{}
+	err := flickr.DoPost(client, response)
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if response.HasErrors() {
+		return nil, errors.New(response.ErrorMsg())
+	}
+
+	return response, nil
+}
+
+type FlickrRemotePhoto struct {
 	photoInfo photos.PhotoInfo
 	client    *flickr.FlickrClient
 	meta *Meta
 }
 
-func (remotePhoto *RemotePhoto) ID() string {
+func (remotePhoto *FlickrRemotePhoto) ID() string {
 	return remotePhoto.photoInfo.Id
 }
 
-func (remotePhoto *RemotePhoto) GetMeta() (*Meta, error) {
+func (remotePhoto *FlickrRemotePhoto) GetMeta() (*Meta, error) {
 
 	if remotePhoto.meta == nil {
 		photoInfoResponse, err := photos.GetInfo(remotePhoto.client, remotePhoto.photoInfo.Id, "")
@@ -134,17 +268,71 @@ func (remotePhoto *RemotePhoto) GetMeta() (*Meta, error) {
 			photoInfoResponse.Photo,
 			photoAllContextsResponse.PhotoAllContexts,
 			photoSizesResponse.Sizes,
-
+			nil,
 		}
 	}
 
 	return remotePhoto.meta, nil
 }
 
+//returns nil if the photo has no location
+func (remotePhoto *FlickrRemotePhoto) GetLocation() (*Location, error) {
+	location, err := getLocation(remotePhoto.client, remotePhoto.photoInfo.Id)
+
+	if err != nil {
+		return nil, errors.Annotate(err, "Failed to retrieve photo location")
+	}
+
+	return location, nil
+}
+
 type Meta struct {
 	photos.PhotoInfo
 	photos.PhotoAllContexts
 	photos.PhotoSizes
+	Location *Location `json:",omitempty"` //only set once the photo is downloaded
+}
+
+type Location struct {
+	Latitude  float64 `xml:"latitude,attr"`
+	Longitude float64 `xml:"longitude,attr"`
+}
+
+type locationResponse struct {
+	flickr.BasicResponse
+	Photo struct {
+		Location Location `xml:"location"`
+	} `xml:"photo"`
+}
+
+//flickr reports this code for photos without a location
+const noLocationErrorCode = 2
+
+//returns nil if the photo has no location
+func getLocation(client *flickr.FlickrClient, id string) (*Location, error) {
+	client.Init()
+	client.EndpointUrl = flickr.API_ENDPOINT
+	client.HTTPVerb = "POST"
+	client.Args.Set("method", "flickr.photos.geo.getLocation")
+	client.Args.Set("photo_id", id)
+	client.OAuthSign()
+
+	response := &locationResponse{}
+	err := flickr.DoPost(client, response)
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if response.HasErrors() {
+		if response.ErrorCode() == noLocationErrorCode {
+			return nil, nil
+		}
+
+		return nil, errors.New(response.ErrorMsg())
+	}
+
+	return &response.Photo.Location, nil
 }
 
 func getJson(client *flickr.FlickrClient, method string, id string) (*flickr.BasicResponse, error) {
@@ -0,0 +1,54 @@
+package flickraccess
+
+import (
+	"github.com/juju/errors"
+	"io"
+	"net/http"
+)
+
+type ContentFetcher interface {
+	Fetch(url string) (io.ReadCloser, error)
+	Size(url string) (int64, error)
+}
+
+type HTTPContentFetcher struct {
+	client *http.Client
+}
+
+func NewHTTPContentFetcher() *HTTPContentFetcher {
+	return &HTTPContentFetcher{
+		client: http.DefaultClient,
+	}
+}
+
+func (fetcher *HTTPContentFetcher) Fetch(url string) (io.ReadCloser, error) {
+	resp, err := fetcher.client.Get(url)
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, errors.Errorf("Unexpected status fetching %v: %v", url, resp.Status)
+	}
+
+	return resp.Body, nil
+}
+
+//returns -1 if the size is not reported
+func (fetcher *HTTPContentFetcher) Size(url string) (int64, error) {
+	resp, err := fetcher.client.Head(url)
+
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, errors.Errorf("Unexpected status checking %v: %v", url, resp.Status)
+	}
+
+	return resp.ContentLength, nil
+}
@@ -0,0 +1,71 @@
+package flickraccess
+
+import (
+	"bufio"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/juju/errors"
+	"golang.org/x/crypto/ssh/terminal"
+	"os"
+	"strings"
+)
+
+//flickr permission levels, each including the ones before it
+const (
+	PERMS_READ   = "read"
+	PERMS_WRITE  = "write"
+	PERMS_DELETE = "delete"
+)
+
+var permsOrder = map[string]int{
+	PERMS_READ:   1,
+	PERMS_WRITE:  2,
+	PERMS_DELETE: 3,
+}
+
+func HasPerms(granted string, required string) bool {
+	return permsOrder[granted] >= permsOrder[required]
+}
+
+//checks that the stored token grants the required permission before any work is done.
+//When run interactively it offers to re-authenticate, otherwise it fails with instructions.
+func Preflight(apiKey string, sharedSecret string, store TokenStore, required string, purpose string) error {
+	info, err := CheckToken(apiKey, sharedSecret, store)
+
+	var problem string
+
+	switch {
+	case errors.IsNotFound(err):
+		problem = fmt.Sprintf("there is no token in %v", store)
+	case errors.IsNotValid(err):
+		problem = err.Error()
+	case err != nil:
+		return errors.Annotate(err, "Failed to check the Flickr token")
+	case !HasPerms(info.Perms, required):
+		problem = fmt.Sprintf("the token in %v only grants %v access", store, info.Perms)
+	default:
+		log.Debugf("Token for %v grants %v access, %v needed for %v", info.Username, info.Perms, required, purpose)
+		return nil
+	}
+
+	message := fmt.Sprintf("%v needs %v access to Flickr, but %v", purpose, required, problem)
+
+	if !confirm(message + ". Re-authenticate now?") {
+		return errors.Errorf("%v. Run 'auth login --perms %v' to re-authenticate", message, required)
+	}
+
+	_, err = Login(apiKey, sharedSecret, store, LoginOptions{Perms: required})
+
+	return errors.Trace(err)
+}
+
+func confirm(question string) bool {
+	if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+		return false
+	}
+
+	fmt.Printf("%v [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+
+	return strings.ToLower(strings.TrimSpace(answer)) == "y"
+}
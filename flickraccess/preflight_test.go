@@ -0,0 +1,13 @@
+package flickraccess
+
+import "testing"
+
+func TestHasPerms(t *testing.T) {
+	if !HasPerms(PERMS_DELETE, PERMS_WRITE) || !HasPerms(PERMS_WRITE, PERMS_WRITE) {
+		t.Error("Expected greater or equal permissions to be sufficient")
+	}
+
+	if HasPerms(PERMS_READ, PERMS_WRITE) || HasPerms("", PERMS_READ) {
+		t.Error("Expected lesser permissions to be insufficient")
+	}
+}
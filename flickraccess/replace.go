@@ -0,0 +1,106 @@
+package flickraccess
+
+import (
+	"bytes"
+	"encoding/xml"
+	"github.com/jpg0/flickr"
+	"github.com/juju/errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+type replaceResponse struct {
+	XMLName xml.Name `xml:"rsp"`
+	Status  string   `xml:"stat,attr"`
+	PhotoId string   `xml:"photoid"`
+	Err     struct {
+		Code int    `xml:"code,attr"`
+		Msg  string `xml:"msg,attr"`
+	} `xml:"err"`
+}
+
+//uploads the file as a new version of an existing photo, which keeps its metadata, sets, comments and faves
+func ReplaceFile(client *flickr.FlickrClient, path string, photoId string) error {
+	client.Init()
+	client.EndpointUrl = flickr.REPLACE_ENDPOINT
+	client.HTTPVerb = "POST"
+	client.Args.Set("photo_id", photoId)
+	client.OAuthSign()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+
+	for key, values := range client.Args {
+		for _, value := range values {
+			err := writer.WriteField(key, value)
+
+			if err != nil {
+				return errors.Trace(err)
+			}
+		}
+	}
+
+	file, err := os.Open(path)
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	defer file.Close()
+
+	part, err := writer.CreateFormFile("photo", filepath.Base(path))
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	_, err = io.Copy(part, file)
+
+	if err != nil {
+		return errors.Annotatef(err, "Failed to read %v", path)
+	}
+
+	err = writer.Close()
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	request, err := http.NewRequest("POST", client.EndpointUrl, body)
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	request.Header.Set("Content-Type", writer.FormDataContentType())
+
+	httpClient := client.HTTPClient
+
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(request)
+
+	if err != nil {
+		return errors.Annotate(err, "Failed to replace photo")
+	}
+
+	defer resp.Body.Close()
+
+	response := &replaceResponse{}
+	err = xml.NewDecoder(resp.Body).Decode(response)
+
+	if err != nil {
+		return errors.Annotatef(err, "Failed to read replace response (%v)", resp.Status)
+	}
+
+	if response.Status != "ok" {
+		return errors.Errorf("Failed to replace photo %v: %v", photoId, response.Err.Msg)
+	}
+
+	return nil
+}
@@ -6,11 +6,15 @@ import (
 	"github.com/jpg0/flickr"
 	"github.com/jpg0/flickr/photos"
 	"github.com/jpg0/flickr/photosets"
+	"golang.org/x/net/context"
 )
 
+//flickr reports this code when adding a photo to a set that it is already in
+const alreadyInSetErrorCode = 3
+
 type SetClient interface {
-	DateOfSet(setName string) (time.Time, error)
-	AddToSet(photoId string, setName string, datePhotoTaken time.Time) error
+	DateOfSet(ctx context.Context, setName string) (time.Time, error)
+	AddToSet(ctx context.Context, photoId string, setName string, datePhotoTaken time.Time) error
 }
 
 type FlickrSetClient struct {
@@ -19,9 +23,9 @@ type FlickrSetClient struct {
 	setNameToId map[string]string
 }
 
-func NewFlickrSetClient(APIKey string, SharedSecret string) (SetClient, error){
+func NewFlickrSetClient(APIKey string, SharedSecret string, store TokenStore) (SetClient, error){
 	client := flickr.NewFlickrClient(APIKey, SharedSecret)
-	token, err := getToken(client)
+	token, err := getToken(client, store)
 
 	if err != nil {
 		return nil, err
@@ -37,8 +41,8 @@ func NewFlickrSetClient(APIKey string, SharedSecret string) (SetClient, error){
 	}, nil
 }
 
-func (client FlickrSetClient) DateOfSet(setName string) (time.Time, error) {
-	id, err := client.setIdFromName(setName)
+func (client FlickrSetClient) DateOfSet(ctx context.Context, setName string) (time.Time, error) {
+	id, err := client.setIdFromName(ctx, setName)
 
 	if err != nil {
 		return time.Time{}, err
@@ -47,7 +51,8 @@ func (client FlickrSetClient) DateOfSet(setName string) (time.Time, error) {
 	date := client.setIdToDate[id]
 
 	if date.IsZero() {
-		setResponse, err := photosets.GetInfo(client.flickrClient, true, id, "")
+		flickrClient := withContext(ctx, client.flickrClient)
+		setResponse, err := photosets.GetInfo(flickrClient, true, id, "")
 
 		if err != nil {
 			return time.Time{}, err
@@ -59,7 +64,7 @@ func (client FlickrSetClient) DateOfSet(setName string) (time.Time, error) {
 
 		primary := setResponse.Set.Primary
 
-		photoResponse, err := photos.GetInfo(client.flickrClient, primary, "")
+		photoResponse, err := photos.GetInfo(flickrClient, primary, "")
 
 		if err != nil {
 			return time.Time{}, err
@@ -82,11 +87,11 @@ func (client FlickrSetClient) DateOfSet(setName string) (time.Time, error) {
 	return date, nil
 }
 
-func (client FlickrSetClient) setIdFromName(setName string) (string, error) {
+func (client FlickrSetClient) setIdFromName(ctx context.Context, setName string) (string, error) {
 	val := client.setNameToId[setName]
 
 	if val == "" {
-		response, err := photosets.GetList(client.flickrClient, true, "", 0)
+		response, err := photosets.GetList(withContext(ctx, client.flickrClient), true, "", 0)
 
 		if err != nil {
 			return "", err
@@ -106,27 +111,28 @@ func (client FlickrSetClient) setIdFromName(setName string) (string, error) {
 	return val, nil
 }
 
-func (client FlickrSetClient) AddToSet(photoId string, setName string, datePhotoTaken time.Time) error {
-	setId, err := client.setIdFromName(setName)
+func (client FlickrSetClient) AddToSet(ctx context.Context, photoId string, setName string, datePhotoTaken time.Time) error {
+	setId, err := client.setIdFromName(ctx, setName)
 
 	if err != nil {
 		return err
 	}
 
 	if setId != "" {
-		response, err := photosets.AddPhoto(client.flickrClient, setId, photoId)
+		response, err := photosets.AddPhoto(withContext(ctx, client.flickrClient), setId, photoId)
 
 		if err != nil {
 			return err
 		}
 
-		if response.HasErrors() {
+		//so that adding can be retried after a partial failure
+		if response.HasErrors() && response.ErrorCode() != alreadyInSetErrorCode {
 			return errors.New(response.ErrorMsg())
 		}
 
 	} else { //if we still don't, create it
 
-		response, err := photosets.Create(client.flickrClient, setName, "", photoId)
+		response, err := photosets.Create(withContext(ctx, client.flickrClient), setName, "", photoId)
 
 		if err != nil {
 			return err
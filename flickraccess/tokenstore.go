@@ -0,0 +1,440 @@
+package flickraccess
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/jpg0/flickr"
+	"github.com/juju/errors"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/crypto/ssh/terminal"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	TOKEN_STORE_FILE      = "file"
+	TOKEN_STORE_ENCRYPTED = "encrypted"
+	TOKEN_STORE_COMMAND   = "command"
+)
+
+//Load and Delete return a NotFound error if there is no stored token
+type TokenStore interface {
+	Load() (*flickr.OAuthToken, error)
+	Save(token *flickr.OAuthToken) error
+	Delete() error
+	String() string
+}
+
+//implemented by config.Config
+type TokenConfig interface {
+	TokenPath() string
+	TokenStoreType() string
+	TokenPassphraseEnv() string
+	TokenCommand() []string
+	AccountName() string
+}
+
+//encrypted stores by path, so the passphrase is asked for once per process however many callers need the token
+var encryptedStores = make(map[string]*encryptedTokenStore)
+var encryptedStoresLock sync.Mutex
+
+func NewTokenStore(config TokenConfig) (TokenStore, error) {
+	legacy := &fileTokenStore{path: config.TokenPath()}
+
+	switch config.TokenStoreType() {
+	case TOKEN_STORE_FILE:
+		return legacy, nil
+	case TOKEN_STORE_ENCRYPTED:
+		return &migratingTokenStore{
+			TokenStore: sharedEncryptedTokenStore(config.TokenPath()+".enc", config.TokenPassphraseEnv()),
+			legacy:     legacy,
+		}, nil
+	case TOKEN_STORE_COMMAND:
+		if len(config.TokenCommand()) == 0 {
+			return nil, errors.New("The command token store needs a command")
+		}
+
+		return &migratingTokenStore{
+			TokenStore: &commandTokenStore{command: config.TokenCommand(), account: config.AccountName()},
+			legacy:     legacy,
+		}, nil
+	default:
+		return nil, errors.Errorf("Unknown token store: %v", config.TokenStoreType())
+	}
+}
+
+//plain YAML, readable only by the owner
+type fileTokenStore struct {
+	path string
+}
+
+func (store *fileTokenStore) Load() (*flickr.OAuthToken, error) {
+	data, err := ioutil.ReadFile(store.path)
+
+	if os.IsNotExist(err) {
+		return nil, errors.NotFoundf("Token %v", store.path)
+	}
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	info, err := os.Stat(store.path)
+
+	if err == nil && info.Mode().Perm()&0077 != 0 {
+		log.Infof("Restricting permissions of %v to its owner", store.path)
+
+		err = os.Chmod(store.path, 0600)
+
+		if err != nil {
+			log.Warnf("Failed to restrict permissions of %v: %v", store.path, err)
+		}
+	}
+
+	return unmarshalToken(data)
+}
+
+func (store *fileTokenStore) Save(token *flickr.OAuthToken) error {
+	data, err := yaml.Marshal(token)
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	return writePrivateFile(store.path, data)
+}
+
+func (store *fileTokenStore) Delete() error {
+	err := os.Remove(store.path)
+
+	if os.IsNotExist(err) {
+		return errors.NotFoundf("Token %v", store.path)
+	}
+
+	return errors.Trace(err)
+}
+
+func (store *fileTokenStore) String() string {
+	return store.path
+}
+
+func sharedEncryptedTokenStore(path string, passphraseEnv string) *encryptedTokenStore {
+	encryptedStoresLock.Lock()
+	defer encryptedStoresLock.Unlock()
+
+	store, ok := encryptedStores[path]
+
+	if !ok {
+		store = &encryptedTokenStore{path: path, passphraseEnv: passphraseEnv}
+		encryptedStores[path] = store
+	}
+
+	return store
+}
+
+type encryptedToken struct {
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+//AES-GCM with a key derived from a passphrase, taken from the environment or prompted for
+type encryptedTokenStore struct {
+	path          string
+	passphraseEnv string
+	passphrase    []byte
+	lock          sync.Mutex
+}
+
+func (store *encryptedTokenStore) Load() (*flickr.OAuthToken, error) {
+	data, err := ioutil.ReadFile(store.path)
+
+	if os.IsNotExist(err) {
+		return nil, errors.NotFoundf("Token %v", store.path)
+	}
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	encrypted := &encryptedToken{}
+
+	err = json.Unmarshal(data, encrypted)
+
+	if err != nil {
+		return nil, errors.Annotatef(err, "Corrupt token file %v", store.path)
+	}
+
+	gcm, err := store.cipher(encrypted.Salt)
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	plain, err := gcm.Open(nil, encrypted.Nonce, encrypted.Ciphertext, nil)
+
+	if err != nil {
+		return nil, errors.Errorf("Failed to decrypt %v, is the passphrase correct?", store.path)
+	}
+
+	return unmarshalToken(plain)
+}
+
+func (store *encryptedTokenStore) Save(token *flickr.OAuthToken) error {
+	plain, err := yaml.Marshal(token)
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	encrypted := &encryptedToken{Salt: make([]byte, 16)}
+
+	_, err = rand.Read(encrypted.Salt)
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	gcm, err := store.cipher(encrypted.Salt)
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	encrypted.Nonce = make([]byte, gcm.NonceSize())
+
+	_, err = rand.Read(encrypted.Nonce)
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	encrypted.Ciphertext = gcm.Seal(nil, encrypted.Nonce, plain, nil)
+
+	data, err := json.Marshal(encrypted)
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	return writePrivateFile(store.path, data)
+}
+
+func (store *encryptedTokenStore) Delete() error {
+	err := os.Remove(store.path)
+
+	if os.IsNotExist(err) {
+		return errors.NotFoundf("Token %v", store.path)
+	}
+
+	return errors.Trace(err)
+}
+
+func (store *encryptedTokenStore) String() string {
+	return store.path + " (encrypted)"
+}
+
+func (store *encryptedTokenStore) cipher(salt []byte) (cipher.AEAD, error) {
+	passphrase, err := store.getPassphrase()
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	key, err := scrypt.Key(passphrase, salt, 1<<15, 8, 1, 32)
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	block, err := aes.NewCipher(key)
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func (store *encryptedTokenStore) getPassphrase() ([]byte, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	if store.passphrase != nil {
+		return store.passphrase, nil
+	}
+
+	if env := os.Getenv(store.passphraseEnv); env != "" {
+		store.passphrase = []byte(env)
+		return store.passphrase, nil
+	}
+
+	if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+		return nil, errors.Errorf("Set %v to the passphrase for %v", store.passphraseEnv, store.path)
+	}
+
+	fmt.Printf("Passphrase for %v: ", store.path)
+	passphrase, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if len(passphrase) == 0 {
+		return nil, errors.New("Empty passphrase")
+	}
+
+	store.passphrase = passphrase
+
+	return passphrase, nil
+}
+
+//delegates to a secret manager: the command is run with "get", "store" or "delete" and the account name appended.
+//get prints the token YAML, or nothing if there is none; store reads it from stdin.
+type commandTokenStore struct {
+	command []string
+	account string
+}
+
+func (store *commandTokenStore) Load() (*flickr.OAuthToken, error) {
+	out, err := store.run("get", nil)
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if len(bytes.TrimSpace(out)) == 0 {
+		return nil, errors.NotFoundf("Token for %v", store.account)
+	}
+
+	return unmarshalToken(out)
+}
+
+func (store *commandTokenStore) Save(token *flickr.OAuthToken) error {
+	data, err := yaml.Marshal(token)
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	_, err = store.run("store", data)
+
+	return errors.Trace(err)
+}
+
+func (store *commandTokenStore) Delete() error {
+	_, err := store.run("delete", nil)
+
+	return errors.Trace(err)
+}
+
+func (store *commandTokenStore) String() string {
+	return fmt.Sprintf("%v (account %v)", strings.Join(store.command, " "), store.account)
+}
+
+func (store *commandTokenStore) run(action string, stdin []byte) ([]byte, error) {
+	args := append(append([]string{}, store.command[1:]...), action, store.account)
+	cmd := exec.Command(store.command[0], args...)
+	cmd.Stderr = os.Stderr
+
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+
+	out, err := cmd.Output()
+
+	if err != nil {
+		return nil, errors.Annotatef(err, "Token command %v %v failed", store.command[0], action)
+	}
+
+	return out, nil
+}
+
+//moves a token from the plain file used by earlier versions into another store
+type migratingTokenStore struct {
+	TokenStore
+	legacy *fileTokenStore
+}
+
+func (store *migratingTokenStore) Load() (*flickr.OAuthToken, error) {
+	token, err := store.TokenStore.Load()
+
+	if !errors.IsNotFound(err) {
+		return token, err
+	}
+
+	token, err = store.legacy.Load()
+
+	if errors.IsNotFound(err) {
+		return nil, errors.NotFoundf("Token in %v", store.TokenStore)
+	}
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	log.Infof("Migrating token from %v to %v", store.legacy, store.TokenStore)
+
+	err = store.TokenStore.Save(token)
+
+	if err != nil {
+		return nil, errors.Annotate(err, "Failed to migrate token")
+	}
+
+	err = store.legacy.Delete()
+
+	if err != nil {
+		log.Warnf("Failed to remove %v after migrating it: %v", store.legacy, err)
+	}
+
+	return token, nil
+}
+
+func unmarshalToken(data []byte) (*flickr.OAuthToken, error) {
+	token := new(flickr.OAuthToken)
+
+	err := yaml.Unmarshal(data, token)
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return token, nil
+}
+
+//written to a temporary file first, so that the token is never briefly readable by others
+func writePrivateFile(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	_, err = tmp.Write(data)
+
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+		return errors.Annotatef(err, "Failed to write %v", path)
+	}
+
+	return nil
+}
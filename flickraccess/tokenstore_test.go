@@ -0,0 +1,130 @@
+package flickraccess
+
+import (
+	"github.com/jpg0/flickr"
+	"github.com/juju/errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+var testToken = &flickr.OAuthToken{OAuthToken: "token", OAuthTokenSecret: "secret", Username: "alice"}
+
+func tempTokenDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "tokens")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir, func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func TestFileTokenStoreIsPrivate(t *testing.T) {
+	dir, cleanup := tempTokenDir(t)
+	defer cleanup()
+
+	store := &fileTokenStore{path: dir + "/.flickrup"}
+
+	if _, err := store.Load(); !errors.IsNotFound(err) {
+		t.Fatalf("Expected NotFound, got %v", err)
+	}
+
+	err := store.Save(testToken)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, _ := os.Stat(store.path)
+
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected mode 0600, got %v", info.Mode().Perm())
+	}
+}
+
+func TestEncryptedTokenStoreMigrates(t *testing.T) {
+	dir, cleanup := tempTokenDir(t)
+	defer cleanup()
+
+	legacy := &fileTokenStore{path: dir + "/.flickrup"}
+	legacy.Save(testToken)
+
+	store := &migratingTokenStore{
+		TokenStore: &encryptedTokenStore{path: legacy.path + ".enc", passphrase: []byte("correct horse")},
+		legacy:     legacy,
+	}
+
+	token, err := store.Load()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertEquals("secret", token.OAuthTokenSecret, t)
+
+	if _, err := os.Stat(legacy.path); !os.IsNotExist(err) {
+		t.Errorf("Expected %v to be removed after migration", legacy.path)
+	}
+
+	data, _ := ioutil.ReadFile(legacy.path + ".enc")
+
+	if len(data) == 0 || strings.Contains(string(data), "secret") {
+		t.Errorf("Expected encrypted token, got %s", data)
+	}
+
+	wrong := &encryptedTokenStore{path: legacy.path + ".enc", passphrase: []byte("wrong")}
+
+	if _, err := wrong.Load(); err == nil {
+		t.Error("Expected decryption with the wrong passphrase to fail")
+	}
+}
+
+type testTokenConfig struct {
+	path string
+}
+
+func (c testTokenConfig) TokenPath() string          { return c.path }
+func (c testTokenConfig) TokenStoreType() string     { return TOKEN_STORE_ENCRYPTED }
+func (c testTokenConfig) TokenPassphraseEnv() string { return "FLICKRUP_TEST_PASSPHRASE" }
+func (c testTokenConfig) TokenCommand() []string     { return nil }
+func (c testTokenConfig) AccountName() string        { return "" }
+
+func TestEncryptedTokenStoreIsUnlockedOnce(t *testing.T) {
+	dir, cleanup := tempTokenDir(t)
+	defer cleanup()
+
+	config := testTokenConfig{path: dir + "/.flickrup"}
+
+	os.Setenv("FLICKRUP_TEST_PASSPHRASE", "correct horse")
+	first, err := NewTokenStore(config)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = first.Save(testToken)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//a second caller must not need the passphrase again
+	os.Unsetenv("FLICKRUP_TEST_PASSPHRASE")
+	second, err := NewTokenStore(config)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := second.Load()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertEquals("secret", token.OAuthTokenSecret, t)
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/jpg0/dropbox"
 	"github.com/juju/errors"
 	"github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
 )
 
 const (
@@ -65,7 +66,7 @@ func DropboxDir() (string, error) {
 	return dbc.Personal.Path, nil
 }
 
-func Transfer(filepath string, tags []string, isDefault bool, isPublic bool, isFamily bool, isFriend bool, recentChange bool, servicePassword string) (string, error) {
+func Transfer(ctx context.Context, filepath string, tags []string, isDefault bool, isPublic bool, isFamily bool, isFriend bool, recentChange bool, servicePassword string) (string, error) {
 
 	file, err := os.Open(filepath)
 
@@ -127,6 +128,7 @@ func Transfer(filepath string, tags []string, isDefault bool, isPublic bool, isF
 		return "", errors.Trace(err)
 	}
 
+	req = req.WithContext(ctx)
 	req.Header.Set("Content-Type", "application/json")
 	if servicePassword != "" {
 		req.SetBasicAuth("flickrup", servicePassword)
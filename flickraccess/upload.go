@@ -3,22 +3,30 @@ package flickraccess
 import (
 	"github.com/jpg0/flickr"
 	"github.com/jpg0/flickrup/processing"
-	"golang.org/x/net/context"
 	"errors"
 	log "github.com/Sirupsen/logrus"
 	"github.com/jpg0/flickrup/config"
 )
 
+//uploads the file in the context, which is cancelled to abandon the upload
 type UploadClient interface {
-	Upload(file processing.TaggedFile, ctx context.Context) processing.ProcessingResult
+	Upload(ctx *processing.ProcessingContext) processing.ProcessingResult
 }
 
 type FlickrUploadClient struct {
 	client *flickr.FlickrClient
 }
 
+var _ UploadClient = &FlickrUploadClient{}
+
 func (client *FlickrUploadClient) Stage() processing.Stage {
 	return func(ctx *processing.ProcessingContext, next processing.Processor) processing.ProcessingResult {
+		//resumed from the journal
+		if ctx.UploadedId != "" {
+			log.Infof("%v was already uploaded as %v", ctx.File.Name(), ctx.UploadedId)
+			return next(ctx)
+		}
+
 		result := client.Upload(ctx)
 
 		if result.ResultType != processing.SuccessResult {
@@ -40,11 +48,35 @@ func (client *FlickrUploadClient) Upload(ctx *processing.ProcessingContext) proc
 	}
 
 	file := ctx.File
+	flickrClient := withContext(ctx.Context, client.client)
+
+	//flickr keeps the existing photo's tags, sets and visibility
+	if ctx.ReplacingId != "" {
+		err := ReplaceFile(flickrClient, file.Filepath(), ctx.ReplacingId)
+
+		if err != nil {
+			return processing.NewErrorResult(err)
+		}
+
+		log.Infof("Replaced photo %v with %v", ctx.ReplacingId, file.Name())
+		ctx.UploadedId = ctx.ReplacingId
+		return processing.NewSuccessResult()
+	}
 
 	params.Tags = file.Keywords().All().Slice()
 
+	if ctx.Config.ChecksumTags {
+		checksum, err := SHA1File(file.Filepath())
+
+		if err != nil {
+			log.Warnf("Failed to hash %v, uploading without a checksum tag: %v", file.Name(), err)
+		} else {
+			params.Tags = append(params.Tags, CHECKSUM_TAG_PREFIX+checksum)
+		}
+	}
+
 	if ctx.Config.TransferService != nil {
-		id, err := Transfer(ctx.Config.TransferService.MapDropboxPath(file.Filepath()), params.Tags, params.IsDefault, params.IsPublic, params.IsFamily, params.IsFriend, ctx.FileUpdated, ctx.Config.TransferService.Password)
+		id, err := Transfer(ctx.Context, ctx.Config.TransferService.MapDropboxPath(file.Filepath()), params.Tags, params.IsDefault, params.IsPublic, params.IsFamily, params.IsFriend, ctx.FileUpdated, ctx.Config.TransferService.Password)
 
 		if err != nil {
 			log.Infof("Failed to transfer: %v", err)
@@ -55,7 +87,7 @@ func (client *FlickrUploadClient) Upload(ctx *processing.ProcessingContext) proc
 		}
 	}
 
-	response, err := flickr.UploadFile(client.client, file.Filepath(), params)
+	response, err := flickr.UploadFile(flickrClient, file.Filepath(), params)
 
 	if err != nil {
 		return processing.NewErrorResult(err)
@@ -73,8 +105,14 @@ func (client *FlickrUploadClient) Upload(ctx *processing.ProcessingContext) proc
 }
 
 func NewUploadClient(config *config.Config) (*FlickrUploadClient, error){
+	store, err := NewTokenStore(config)
+
+	if err != nil {
+		return nil, err
+	}
+
 	client := flickr.NewFlickrClient(config.APIKey, config.SharedSecret)
-	token, err := getToken(client)
+	token, err := getToken(client, store)
 
 	if err != nil {
 		return nil, err
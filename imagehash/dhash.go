@@ -0,0 +1,111 @@
+package imagehash
+
+import (
+	"fmt"
+	"github.com/juju/errors"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const width = 9
+const height = 8
+
+//whether the file is an image that can be hashed
+func Supported(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jpg", ".jpeg", ".png", ".gif":
+		return true
+	}
+
+	return false
+}
+
+//a difference hash: each bit records whether a pixel is brighter than its right-hand neighbour
+//in a 9x8 greyscale thumbnail, so resizing and recompression change few bits
+func DHash(img image.Image) uint64 {
+	grey := shrink(img)
+
+	var rv uint64
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width-1; x++ {
+			rv <<= 1
+
+			if grey[y][x] > grey[y][x+1] {
+				rv |= 1
+			}
+		}
+	}
+
+	return rv
+}
+
+//averages the luminance of each cell of a width x height grid over the image
+func shrink(img image.Image) [height][width]float64 {
+	var sums [height][width]float64
+	var counts [height][width]float64
+
+	bounds := img.Bounds()
+	w := bounds.Dx()
+	h := bounds.Dy()
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		cy := (y - bounds.Min.Y) * height / h
+
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			cx := (x - bounds.Min.X) * width / w
+			r, g, b, _ := img.At(x, y).RGBA()
+
+			sums[cy][cx] += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+			counts[cy][cx]++
+		}
+	}
+
+	for y := range sums {
+		for x := range sums[y] {
+			if counts[y][x] > 0 {
+				sums[y][x] /= counts[y][x]
+			}
+		}
+	}
+
+	return sums
+}
+
+func HashFile(path string) (uint64, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+
+	if err != nil {
+		return 0, errors.Annotatef(err, "Failed to decode %v", path)
+	}
+
+	return DHash(img), nil
+}
+
+//the number of differing bits; 0 is identical, up to about 10 is usually the same picture
+func Distance(a uint64, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+func Format(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+func Parse(s string) (uint64, error) {
+	return strconv.ParseUint(s, 16, 64)
+}
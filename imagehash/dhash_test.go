@@ -0,0 +1,51 @@
+package imagehash
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func gradient(w int, h int, invert bool) image.Image {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := uint8(x * 255 / w)
+
+			if invert {
+				v = 255 - v
+			}
+
+			img.SetGray(x, y, color.Gray{Y: v})
+		}
+	}
+
+	return img
+}
+
+func TestResizedImagesHashAlike(t *testing.T) {
+	a := DHash(gradient(640, 480, false))
+	b := DHash(gradient(320, 240, false))
+
+	if Distance(a, b) > 2 {
+		t.Errorf("Expected resized images to be similar, distance was %v", Distance(a, b))
+	}
+}
+
+func TestDifferentImagesHashApart(t *testing.T) {
+	a := DHash(gradient(640, 480, false))
+	b := DHash(gradient(640, 480, true))
+
+	if Distance(a, b) < 32 {
+		t.Errorf("Expected different images to be dissimilar, distance was %v", Distance(a, b))
+	}
+}
+
+func TestFormatAndParse(t *testing.T) {
+	parsed, err := Parse(Format(0xdeadbeef))
+
+	if err != nil || parsed != 0xdeadbeef {
+		t.Errorf("Test failed, expected: '%x', got:  '%x' (%v)", 0xdeadbeef, parsed, err)
+	}
+}
@@ -0,0 +1,38 @@
+package index
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/juju/errors"
+	"hash"
+	"io"
+	"os"
+)
+
+func NewHasher() hash.Hash {
+	return sha256.New()
+}
+
+func Checksum(h hash.Hash) string {
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func HashFile(path string) (string, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+
+	defer file.Close()
+
+	h := NewHasher()
+
+	_, err = io.Copy(h, file)
+
+	if err != nil {
+		return "", errors.Annotatef(err, "Failed to hash %v", path)
+	}
+
+	return Checksum(h), nil
+}
@@ -0,0 +1,52 @@
+package index
+
+import (
+	"encoding/json"
+	bolt "go.etcd.io/bbolt"
+	"github.com/juju/errors"
+	"time"
+)
+
+var decisionsBucket = []byte("duplicates")
+
+const (
+	DECISION_HARDLINK   = "hardlink"
+	DECISION_QUARANTINE = "quarantine"
+)
+
+//records that Path was found to duplicate KeptPath, and what was done about it
+type Decision struct {
+	Path     string    `json:"path"`
+	KeptPath string    `json:"kept_path"`
+	Checksum string    `json:"checksum"`
+	Action   string    `json:"action"`
+	Time     time.Time `json:"time"`
+}
+
+func (idx *Index) RecordDecision(decision *Decision) error {
+	data, err := json.Marshal(decision)
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	return idx.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(decisionsBucket).Put([]byte(decision.Path), data)
+	})
+}
+
+//returns nil if no decision has been made for the path
+func (idx *Index) DecisionFor(path string) (decision *Decision, err error) {
+	err = idx.view(func(tx *bolt.Tx) error {
+		data := tx.Bucket(decisionsBucket).Get([]byte(path))
+
+		if data == nil {
+			return nil
+		}
+
+		decision = &Decision{}
+		return json.Unmarshal(data, decision)
+	})
+
+	return
+}
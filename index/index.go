@@ -0,0 +1,317 @@
+package index
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/juju/errors"
+	bolt "go.etcd.io/bbolt"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var itemsBucket = []byte("items")
+var photoIdsBucket = []byte("photo_ids")
+var namesBucket = []byte("names")
+
+const lockTimeout = 10 * time.Second
+
+//an archived photo or video
+type Item struct {
+	PhotoID    string    `json:"photo_id,omitempty"`
+	Path       string    `json:"path"`
+	Tags       []string  `json:"tags,omitempty"`
+	Sets       []string  `json:"sets,omitempty"`
+	DateTaken  time.Time `json:"date_taken"`
+	Visibility string    `json:"visibility,omitempty"`
+	HasGeo     bool      `json:"has_geo,omitempty"`
+	Latitude   float64   `json:"latitude,omitempty"`
+	Longitude  float64   `json:"longitude,omitempty"`
+	Checksum   string    `json:"checksum,omitempty"`
+
+	//see imagehash; empty for videos and files which could not be decoded
+	PerceptualHash string `json:"phash,omitempty"`
+}
+
+//the database is opened per operation, so that flickrup and flickrdown can share it
+type Index struct {
+	path string
+	mu   sync.Mutex //the file lock does not serialise opens within one process
+}
+
+func New(path string) (*Index, error) {
+	idx := &Index{path: path}
+
+	err := idx.update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{itemsBucket, photoIdsBucket, decisionsBucket} {
+			_, err := tx.CreateBucketIfNotExists(bucket)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		//indexes created before names were looked up need filling in
+		if tx.Bucket(namesBucket) != nil {
+			return nil
+		}
+
+		names, err := tx.CreateBucket(namesBucket)
+
+		if err != nil {
+			return err
+		}
+
+		return tx.Bucket(itemsBucket).ForEach(func(k, v []byte) error {
+			item := &Item{}
+
+			err := json.Unmarshal(v, item)
+
+			if err != nil {
+				return errors.Annotatef(err, "Corrupt index entry for %s", k)
+			}
+
+			return names.Put(nameKey(item), k)
+		})
+	})
+
+	if err != nil {
+		return nil, errors.Annotate(err, "Failed to initialise index")
+	}
+
+	return idx, nil
+}
+
+func (idx *Index) open(readOnly bool) (*bolt.DB, error) {
+	db, err := bolt.Open(idx.path, 0600, &bolt.Options{Timeout: lockTimeout, ReadOnly: readOnly})
+
+	if err != nil {
+		return nil, errors.Annotatef(err, "Failed to open index %v", idx.path)
+	}
+
+	return db, nil
+}
+
+func (idx *Index) update(f func(tx *bolt.Tx) error) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	db, err := idx.open(false)
+
+	if err != nil {
+		return err
+	}
+
+	defer db.Close()
+
+	return db.Update(f)
+}
+
+func (idx *Index) view(f func(tx *bolt.Tx) error) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	db, err := idx.open(true)
+
+	if err != nil {
+		return err
+	}
+
+	defer db.Close()
+
+	return db.View(f)
+}
+
+//adds or replaces the item stored at item.Path
+func (idx *Index) Put(item *Item) error {
+	data, err := json.Marshal(item)
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	return idx.update(func(tx *bolt.Tx) error {
+		previous, err := getItem(tx, item.Path)
+
+		if err != nil {
+			return err
+		}
+
+		if previous != nil {
+			err = tx.Bucket(namesBucket).Delete(nameKey(previous))
+
+			if err != nil {
+				return errors.Trace(err)
+			}
+		}
+
+		err = tx.Bucket(itemsBucket).Put([]byte(item.Path), data)
+
+		if err != nil {
+			return errors.Trace(err)
+		}
+
+		err = tx.Bucket(namesBucket).Put(nameKey(item), []byte(item.Path))
+
+		if err != nil {
+			return errors.Trace(err)
+		}
+
+		if item.PhotoID != "" {
+			return tx.Bucket(photoIdsBucket).Put([]byte(item.PhotoID), []byte(item.Path))
+		}
+
+		return nil
+	})
+}
+
+func (idx *Index) Remove(path string) error {
+	return idx.update(func(tx *bolt.Tx) error {
+		item, err := getItem(tx, path)
+
+		if err != nil || item == nil {
+			return err
+		}
+
+		if item.PhotoID != "" {
+			ids := tx.Bucket(photoIdsBucket)
+
+			if string(ids.Get([]byte(item.PhotoID))) == path {
+				err = ids.Delete([]byte(item.PhotoID))
+
+				if err != nil {
+					return errors.Trace(err)
+				}
+			}
+		}
+
+		err = tx.Bucket(namesBucket).Delete(nameKey(item))
+
+		if err != nil {
+			return errors.Trace(err)
+		}
+
+		return tx.Bucket(itemsBucket).Delete([]byte(path))
+	})
+}
+
+//returns nil if there is no item for the path
+func (idx *Index) ByPath(path string) (item *Item, err error) {
+	err = idx.view(func(tx *bolt.Tx) error {
+		item, err = getItem(tx, path)
+		return err
+	})
+
+	return
+}
+
+//returns nil if the photo has not been archived
+func (idx *Index) ByPhotoID(id string) (item *Item, err error) {
+	err = idx.view(func(tx *bolt.Tx) error {
+		path := tx.Bucket(photoIdsBucket).Get([]byte(id))
+
+		if path == nil {
+			return nil
+		}
+
+		item, err = getItem(tx, string(path))
+		return err
+	})
+
+	return
+}
+
+//items whose file has the given name and which were taken at the given time
+func (idx *Index) ByNameAndDate(name string, taken time.Time) (items []*Item, err error) {
+	prefix := nameDatePrefix(name, taken)
+
+	err = idx.view(func(tx *bolt.Tx) error {
+		c := tx.Bucket(namesBucket).Cursor()
+
+		for k, path := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, path = c.Next() {
+			item, err := getItem(tx, string(path))
+
+			if err != nil {
+				return err
+			}
+
+			if item != nil {
+				items = append(items, item)
+			}
+		}
+		return nil
+	})
+
+	return
+}
+
+//f must not call back into the index
+func (idx *Index) ForEach(f func(item *Item) error) error {
+	return idx.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(itemsBucket).ForEach(func(k, v []byte) error {
+			item := &Item{}
+
+			err := json.Unmarshal(v, item)
+
+			if err != nil {
+				return errors.Annotatef(err, "Corrupt index entry for %s", k)
+			}
+
+			return f(item)
+		})
+	})
+}
+
+//returns nil if no uploaded item has the checksum
+func (idx *Index) ByChecksum(checksum string) (*Item, error) {
+	var rv *Item
+
+	err := idx.ForEach(func(item *Item) error {
+		if rv == nil && item.PhotoID != "" && item.Checksum == checksum {
+			rv = item
+		}
+		return nil
+	})
+
+	return rv, err
+}
+
+func (idx *Index) Search(query *Query) ([]*Item, error) {
+	rv := make([]*Item, 0)
+
+	err := idx.ForEach(func(item *Item) error {
+		if query.Matches(item) {
+			rv = append(rv, item)
+		}
+		return nil
+	})
+
+	return rv, err
+}
+
+func getItem(tx *bolt.Tx, path string) (*Item, error) {
+	data := tx.Bucket(itemsBucket).Get([]byte(path))
+
+	if data == nil {
+		return nil, nil
+	}
+
+	item := &Item{}
+
+	err := json.Unmarshal(data, item)
+
+	if err != nil {
+		return nil, errors.Annotatef(err, "Corrupt index entry for %s", path)
+	}
+
+	return item, nil
+}
+
+//the key in the names bucket, unique per path
+func nameKey(item *Item) []byte {
+	return append(nameDatePrefix(filepath.Base(item.Path), item.DateTaken), item.Path...)
+}
+
+func nameDatePrefix(name string, taken time.Time) []byte {
+	return []byte(name + "\x00" + taken.UTC().Format(time.RFC3339Nano) + "\x00")
+}
@@ -0,0 +1,119 @@
+package index
+
+import (
+	bolt "go.etcd.io/bbolt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func openTestIndex(t *testing.T) (*Index, func()) {
+	dir, err := ioutil.TempDir("", "index")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idx, err := New(dir + "/index.db")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return idx, func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func TestPutAndLookup(t *testing.T) {
+	idx, cleanup := openTestIndex(t)
+	defer cleanup()
+
+	err := idx.Put(beach)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	item, err := idx.ByPhotoID("123")
+
+	if err != nil || item == nil || item.Path != beach.Path {
+		t.Errorf("Expected to find %v by photo ID, got %v (%v)", beach.Path, item, err)
+	}
+
+	q, _ := ParseQuery("tags:sea")
+	items, err := idx.Search(q)
+
+	if err != nil || len(items) != 1 {
+		t.Errorf("Expected one search result, got %v (%v)", items, err)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	idx, cleanup := openTestIndex(t)
+	defer cleanup()
+
+	idx.Put(beach)
+
+	err := idx.Remove(beach.Path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	item, err := idx.ByPhotoID("123")
+
+	if err != nil || item != nil {
+		t.Errorf("Expected item to be removed, got %v (%v)", item, err)
+	}
+}
+
+func TestByNameAndDate(t *testing.T) {
+	idx, cleanup := openTestIndex(t)
+	defer cleanup()
+
+	taken := time.Date(2016, 5, 4, 10, 11, 12, 0, time.UTC)
+	idx.Put(&Item{PhotoID: "1", Path: "/archive/a/photo.jpg", DateTaken: taken})
+	idx.Put(&Item{PhotoID: "2", Path: "/archive/b/photo.jpg", DateTaken: taken})
+	idx.Put(&Item{PhotoID: "3", Path: "/archive/a/photo.jpg.bak", DateTaken: taken})
+
+	items, err := idx.ByNameAndDate("photo.jpg", taken.In(time.FixedZone("CEST", 2*60*60)))
+
+	if err != nil || len(items) != 2 {
+		t.Fatalf("Expected two items, got %v (%v)", items, err)
+	}
+
+	//moving the date or removing an item drops its old entry
+	idx.Put(&Item{PhotoID: "1", Path: "/archive/a/photo.jpg", DateTaken: taken.Add(time.Hour)})
+	idx.Remove("/archive/b/photo.jpg")
+
+	items, err = idx.ByNameAndDate("photo.jpg", taken)
+
+	if err != nil || len(items) != 0 {
+		t.Errorf("Expected no items, got %v (%v)", items, err)
+	}
+}
+
+func TestNamesAreIndexedForExistingDatabases(t *testing.T) {
+	idx, cleanup := openTestIndex(t)
+	defer cleanup()
+
+	idx.Put(beach)
+
+	idx.update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket(namesBucket)
+	})
+
+	idx, err := New(idx.path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := idx.ByNameAndDate("beach.jpg", beach.DateTaken)
+
+	if err != nil || len(items) != 1 {
+		t.Errorf("Expected the existing item to be found by name, got %v (%v)", items, err)
+	}
+}
@@ -0,0 +1,143 @@
+package index
+
+import (
+	"github.com/juju/errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type term struct {
+	key   string
+	value string
+}
+
+//a set of terms of the form key:value (or key:"quoted value"), all of which must match
+type Query struct {
+	terms []term
+}
+
+func ParseQuery(s string) (*Query, error) {
+	tokens, err := tokenize(s)
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	q := &Query{terms: make([]term, 0, len(tokens))}
+
+	for _, token := range tokens {
+		t := term{key: "path", value: token}
+
+		if i := strings.Index(token, ":"); i > 0 {
+			t = term{key: strings.ToLower(token[:i]), value: token[i+1:]}
+		}
+
+		err = validateTerm(t)
+
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+
+		q.terms = append(q.terms, t)
+	}
+
+	return q, nil
+}
+
+func tokenize(s string) ([]string, error) {
+	rv := make([]string, 0)
+	current := make([]rune, 0)
+	quoted := false
+
+	for _, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case (r == ' ' || r == '\t') && !quoted:
+			if len(current) > 0 {
+				rv = append(rv, string(current))
+				current = current[:0]
+			}
+		default:
+			current = append(current, r)
+		}
+	}
+
+	if quoted {
+		return nil, errors.Errorf("Unterminated quote in query: %v", s)
+	}
+
+	if len(current) > 0 {
+		rv = append(rv, string(current))
+	}
+
+	return rv, nil
+}
+
+func validateTerm(t term) error {
+	switch t.key {
+	case "tag", "tags", "set", "sets", "id", "visibility", "path":
+		return nil
+	case "year":
+		_, err := strconv.Atoi(t.value)
+		return errors.Annotatef(err, "Invalid year: %v", t.value)
+	case "month":
+		_, err := time.Parse("2006-01", t.value)
+		return errors.Annotatef(err, "Invalid month, expected yyyy-mm: %v", t.value)
+	case "date":
+		_, err := time.Parse("2006-01-02", t.value)
+		return errors.Annotatef(err, "Invalid date, expected yyyy-mm-dd: %v", t.value)
+	case "geo":
+		_, err := strconv.ParseBool(t.value)
+		return errors.Annotatef(err, "Invalid geo, expected true or false: %v", t.value)
+	default:
+		return errors.Errorf("Unknown search key: %v", t.key)
+	}
+}
+
+func (q *Query) Matches(item *Item) bool {
+	for _, t := range q.terms {
+		if !t.matches(item) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (t term) matches(item *Item) bool {
+	switch t.key {
+	case "tag", "tags":
+		return containsFold(item.Tags, t.value)
+	case "set", "sets":
+		return containsFold(item.Sets, t.value)
+	case "id":
+		return item.PhotoID == t.value
+	case "visibility":
+		return strings.EqualFold(item.Visibility, t.value)
+	case "path":
+		return strings.Contains(strings.ToLower(item.Path), strings.ToLower(t.value))
+	case "year":
+		return strconv.Itoa(item.DateTaken.Year()) == t.value
+	case "month":
+		return item.DateTaken.Format("2006-01") == t.value
+	case "date":
+		return item.DateTaken.Format("2006-01-02") == t.value
+	case "geo":
+		b, _ := strconv.ParseBool(t.value)
+		return item.HasGeo == b
+	}
+
+	return false
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+
+	return false
+}
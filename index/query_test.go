@@ -0,0 +1,47 @@
+package index
+
+import (
+	"testing"
+	"time"
+)
+
+var beach = &Item{
+	PhotoID:   "123",
+	Path:      "/archive/2016/05/Holiday/beach.jpg",
+	Tags:      []string{"beach", "sea"},
+	Sets:      []string{"Holiday 2016"},
+	DateTaken: time.Date(2016, 5, 4, 10, 0, 0, 0, time.UTC),
+}
+
+func assertMatch(query string, expected bool, t *testing.T) {
+	q, err := ParseQuery(query)
+
+	if err != nil {
+		t.Fatalf("Failed to parse %v: %v", query, err)
+	}
+
+	if q.Matches(beach) != expected {
+		t.Errorf("Test failed, expected %v for query '%v'", expected, query)
+	}
+}
+
+func TestQueryMatches(t *testing.T) {
+	assertMatch(`tags:beach year:2016 set:"Holiday 2016"`, true, t)
+	assertMatch(`tag:BEACH month:2016-05`, true, t)
+	assertMatch(`holiday`, true, t)
+	assertMatch(`geo:false id:123`, true, t)
+}
+
+func TestQueryDoesNotMatch(t *testing.T) {
+	assertMatch(`tags:beach year:2015`, false, t)
+	assertMatch(`set:Holiday`, false, t)
+	assertMatch(`date:2016-05-05`, false, t)
+}
+
+func TestInvalidQueries(t *testing.T) {
+	for _, query := range []string{`colour:red`, `year:last`, `set:"Holiday`} {
+		if _, err := ParseQuery(query); err == nil {
+			t.Errorf("Expected error parsing '%v'", query)
+		}
+	}
+}
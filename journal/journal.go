@@ -0,0 +1,279 @@
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	log "github.com/Sirupsen/logrus"
+	"github.com/juju/errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+//how far through the pipeline a file got, in order
+const (
+	STATE_UPLOADING  = "uploading" //written before uploading, so a crash mid-upload is noticed
+	STATE_UPLOADED   = "uploaded"
+	STATE_SETS_ADDED = "sets_added"
+	STATE_ARCHIVED   = "archived"
+	STATE_DONE       = "done" //removes the entry when replayed
+)
+
+//a file part way through the pipeline, identified by the checksum of its content
+type Entry struct {
+	Checksum   string    `json:"checksum"`
+	Path       string    `json:"path"`
+	State      string    `json:"state"`
+	UploadedId string    `json:"uploaded_id,omitempty"`
+	SetsAdded  []string  `json:"sets_added,omitempty"`
+	ArchivedAs string    `json:"archived_as,omitempty"`
+	Updated    time.Time `json:"updated"`
+}
+
+//a write-ahead log of upload progress, appended to and synced after every stage so that a restarted
+//run resumes files rather than uploading them again. Safe for concurrent use.
+type Journal struct {
+	path    string
+	mu      sync.Mutex
+	file    *os.File
+	entries map[string]*Entry
+}
+
+//replays the journal at path, dropping finished entries and those whose file has gone, then compacts it
+func Open(path string) (*Journal, error) {
+	entries, err := replay(path)
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	//archived files have been moved, and any others were moved by hand, so there is nothing to resume
+	for checksum, entry := range entries {
+		if _, err := os.Stat(entry.Path); os.IsNotExist(err) {
+			if entry.State != STATE_ARCHIVED {
+				log.Warnf("Forgetting journal entry for %v, which was %v but is no longer in the watch dir", entry.Path, entry.State)
+			}
+
+			delete(entries, checksum)
+		}
+	}
+
+	journal := &Journal{
+		path:    path,
+		entries: entries,
+	}
+
+	err = journal.compact()
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return journal, nil
+}
+
+//the entries left by previous runs, without opening the journal for writing
+func Read(path string) ([]*Entry, error) {
+	entries, err := replay(path)
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	journal := &Journal{entries: entries}
+
+	return journal.pending(), nil
+}
+
+func replay(path string) (map[string]*Entry, error) {
+	entries := make(map[string]*Entry)
+
+	file, err := os.Open(path)
+
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	line := 0
+
+	for scanner.Scan() {
+		line++
+		entry := &Entry{}
+
+		err = json.Unmarshal(scanner.Bytes(), entry)
+
+		//a torn final write is expected after a crash
+		if err != nil || entry.Checksum == "" {
+			log.Warnf("Ignoring unreadable line %v of journal %v", line, path)
+			continue
+		}
+
+		if entry.State == STATE_DONE {
+			delete(entries, entry.Checksum)
+		} else {
+			entries[entry.Checksum] = entry
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Annotatef(err, "Failed to read journal %v", path)
+	}
+
+	return entries, nil
+}
+
+//rewrites the journal with only the open entries, then reopens it for appending
+func (journal *Journal) compact() error {
+	if journal.file != nil {
+		journal.file.Close()
+		journal.file = nil
+	}
+
+	err := os.MkdirAll(filepath.Dir(journal.path), 0755)
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	if len(journal.entries) == 0 {
+		err = os.Truncate(journal.path, 0)
+
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Trace(err)
+		}
+	} else {
+		temp := journal.path + ".tmp"
+		file, err := os.OpenFile(temp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+
+		if err != nil {
+			return errors.Trace(err)
+		}
+
+		for _, entry := range journal.pending() {
+			err = writeEntry(file, entry)
+
+			if err != nil {
+				file.Close()
+				return errors.Trace(err)
+			}
+		}
+
+		err = file.Close()
+
+		if err != nil {
+			return errors.Trace(err)
+		}
+
+		err = os.Rename(temp, journal.path)
+
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	journal.file, err = os.OpenFile(journal.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+
+	return errors.Annotatef(err, "Failed to open journal %v", journal.path)
+}
+
+func writeEntry(file *os.File, entry *Entry) error {
+	line, err := json.Marshal(entry)
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	_, err = file.Write(append(line, '\n'))
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	return errors.Trace(file.Sync())
+}
+
+//returns a copy of the entry for the content, or nil if it has not been started
+func (journal *Journal) Lookup(checksum string) *Entry {
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+
+	entry := journal.entries[checksum]
+
+	if entry == nil {
+		return nil
+	}
+
+	rv := *entry
+	return &rv
+}
+
+//durably records the entry before returning
+func (journal *Journal) Record(entry Entry) error {
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+
+	entry.Updated = time.Now()
+
+	err := writeEntry(journal.file, &entry)
+
+	if err != nil {
+		return errors.Annotatef(err, "Failed to write journal %v", journal.path)
+	}
+
+	if entry.State == STATE_DONE {
+		delete(journal.entries, entry.Checksum)
+
+		if len(journal.entries) == 0 {
+			return errors.Trace(journal.compact())
+		}
+	} else {
+		journal.entries[entry.Checksum] = &entry
+	}
+
+	return nil
+}
+
+func (journal *Journal) Complete(checksum string) error {
+	return journal.Record(Entry{Checksum: checksum, State: STATE_DONE})
+}
+
+//the entries not yet done, oldest first
+func (journal *Journal) Pending() []*Entry {
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+
+	return journal.pending()
+}
+
+func (journal *Journal) pending() []*Entry {
+	rv := make([]*Entry, 0, len(journal.entries))
+
+	for _, entry := range journal.entries {
+		rv = append(rv, entry)
+	}
+
+	sort.Sort(byUpdated(rv))
+
+	return rv
+}
+
+type byUpdated []*Entry
+
+func (a byUpdated) Len() int {
+	return len(a)
+}
+func (a byUpdated) Swap(i, j int) {
+	a[i], a[j] = a[j], a[i]
+}
+func (a byUpdated) Less(i, j int) bool {
+	return a[i].Updated.Before(a[j].Updated)
+}
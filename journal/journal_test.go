@@ -0,0 +1,141 @@
+package journal
+
+import (
+	"github.com/jpg0/flickrup/config"
+	"github.com/jpg0/flickrup/processing"
+	"github.com/jpg0/flickrup/testlib"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func tempFile(t *testing.T, dir string, name string) string {
+	path := filepath.Join(dir, name)
+
+	err := ioutil.WriteFile(path, []byte(name), 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestReplayKeepsUnfinishedEntries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, ".flickrup-journal")
+	photo := tempFile(t, dir, "photo.jpg")
+
+	j, err := Open(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	j.Record(Entry{Checksum: "abc", Path: photo, State: STATE_UPLOADED, UploadedId: "123"})
+	j.Record(Entry{Checksum: "def", Path: photo, State: STATE_UPLOADING})
+	j.Complete("def")
+
+	//as if the process died part way through writing
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString(`{"checksum": "gh`)
+	f.Close()
+
+	j, err = Open(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry := j.Lookup("abc")
+
+	if entry == nil || entry.State != STATE_UPLOADED || entry.UploadedId != "123" {
+		t.Fatalf("Expected uploaded entry to be replayed, got %+v", entry)
+	}
+
+	if j.Lookup("def") != nil {
+		t.Error("Expected completed entry to be dropped")
+	}
+
+	os.Remove(photo)
+
+	pending, err := Read(path)
+
+	if err != nil || len(pending) != 1 {
+		t.Fatalf("Expected one pending entry, got %v (%v)", pending, err)
+	}
+
+	j, err = Open(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(j.Pending()) != 0 {
+		t.Errorf("Expected entry for a file no longer in the watch dir to be dropped, got %+v", j.Pending())
+	}
+}
+
+func TestStageResumesUploadedFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, ".flickrup-journal")
+	photo := tempFile(t, dir, "photo.jpg")
+
+	j, err := Open(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := testlib.NewFakeTaggedFile("photo.jpg", photo, make(map[string]string), nil, time.Time{})
+
+	//the first run uploads, then fails adding to sets
+	result := processing.Chain(j.Stage(), func(ctx *processing.ProcessingContext, next processing.Processor) processing.ProcessingResult {
+		ctx.UploadedId = "123"
+		return next(ctx)
+	}, j.Checkpoint(STATE_UPLOADED), func(ctx *processing.ProcessingContext, next processing.Processor) processing.ProcessingResult {
+		return processing.NewErrorResult(os.ErrPermission)
+	})(processing.NewProcessingContext(&config.Config{}, file, nil))
+
+	if result.ResultType != processing.ErrorResult {
+		t.Fatalf("Expected first run to fail, got %v", result)
+	}
+
+	j, err = Open(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := processing.NewProcessingContext(&config.Config{}, file, nil)
+
+	result = processing.Chain(j.Stage())(ctx)
+
+	if result.ResultType != processing.SuccessResult {
+		t.Fatalf("Expected second run to succeed, got %v", result)
+	}
+
+	if ctx.UploadedId != "123" {
+		t.Errorf("Expected uploaded ID to be resumed, got '%v'", ctx.UploadedId)
+	}
+
+	if len(j.Pending()) != 0 {
+		t.Errorf("Expected entry to be completed, got %+v", j.Pending())
+	}
+}
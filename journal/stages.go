@@ -0,0 +1,79 @@
+package journal
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/jpg0/flickrup/index"
+	"github.com/jpg0/flickrup/processing"
+	"github.com/juju/errors"
+)
+
+//must come before the upload stage; restores the progress of a file that a previous run did not finish,
+//so that the following stages can skip what was already done, and completes the entry once the rest succeed
+func (journal *Journal) Stage() processing.Stage {
+	return func(ctx *processing.ProcessingContext, next processing.Processor) processing.ProcessingResult {
+		if ctx.Checksum == "" {
+			checksum, err := index.HashFile(ctx.File.Filepath())
+
+			if err != nil {
+				return processing.NewErrorResult(errors.Annotate(err, "Failed to hash file for journal"))
+			}
+
+			ctx.Checksum = checksum
+		}
+
+		entry := journal.Lookup(ctx.Checksum)
+
+		if entry != nil {
+			switch entry.State {
+			case STATE_UPLOADING:
+				log.Warnf("A previous upload of %v was interrupted and may have completed, uploading again", ctx.File.Name())
+			default:
+				log.Infof("Resuming %v, which was %v as %v", ctx.File.Name(), entry.State, entry.UploadedId)
+				ctx.UploadedId = entry.UploadedId
+				ctx.SetsAdded = entry.SetsAdded
+			}
+		}
+
+		if ctx.UploadedId == "" {
+			err := journal.Record(Entry{Checksum: ctx.Checksum, Path: ctx.File.Filepath(), State: STATE_UPLOADING})
+
+			if err != nil {
+				return processing.NewErrorResult(errors.Trace(err))
+			}
+		}
+
+		result := next(ctx)
+
+		if result.ResultType != processing.SuccessResult {
+			return result
+		}
+
+		err := journal.Complete(ctx.Checksum)
+
+		if err != nil {
+			log.Warnf("Failed to complete journal entry for %v: %v", ctx.File.Name(), err)
+		}
+
+		return result
+	}
+}
+
+//records that the stages before it succeeded, e.g. Checkpoint(STATE_UPLOADED) after the upload stage
+func (journal *Journal) Checkpoint(state string) processing.Stage {
+	return func(ctx *processing.ProcessingContext, next processing.Processor) processing.ProcessingResult {
+		err := journal.Record(Entry{
+			Checksum:   ctx.Checksum,
+			Path:       ctx.File.Filepath(),
+			State:      state,
+			UploadedId: ctx.UploadedId,
+			SetsAdded:  ctx.SetsAdded,
+			ArchivedAs: ctx.ArchivedAs,
+		})
+
+		if err != nil {
+			return processing.NewErrorResult(errors.Trace(err))
+		}
+
+		return next(ctx)
+	}
+}
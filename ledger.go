@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/juju/errors"
+	"github.com/rickb777/date"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const FAILURE_LEDGER_FILE = ".flickrdown-failures.json"
+
+//how far processing of a photo got before it failed
+const (
+	STAGE_SEARCH     = "search"
+	STAGE_METADATA   = "metadata"
+	STAGE_PATH       = "path"
+	STAGE_WRITE_META = "write_meta"
+	STAGE_DOWNLOAD   = "download"
+)
+
+//a photo, or a whole day if it could not be searched, that failed to download
+type Failure struct {
+	PhotoID     string    `json:"photo_id,omitempty"`
+	Day         string    `json:"day"`
+	Stage       string    `json:"stage"`
+	Error       string    `json:"error"`
+	Attempts    int       `json:"attempts"`
+	LastAttempt time.Time `json:"last_attempt"`
+}
+
+func (f *Failure) key() string {
+	if f.PhotoID != "" {
+		return f.PhotoID
+	}
+
+	return "day:" + f.Day
+}
+
+//records failures across runs, so that they can be retried; safe for concurrent use
+type Ledger struct {
+	path       string
+	mu         sync.Mutex
+	failures   map[string]*Failure
+	downloaded int
+	failed     int
+	failedDays int
+}
+
+func NewLedger(path string) *Ledger {
+	return &Ledger{
+		path:     path,
+		failures: make(map[string]*Failure),
+	}
+}
+
+//a missing ledger is empty
+func LoadLedger(path string) (*Ledger, error) {
+	ledger := NewLedger(path)
+
+	data, err := ioutil.ReadFile(path)
+
+	if os.IsNotExist(err) {
+		return ledger, nil
+	}
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	failures := make([]*Failure, 0)
+
+	err = json.Unmarshal(data, &failures)
+
+	if err != nil {
+		return nil, errors.Annotatef(err, "Corrupt failure ledger %v", path)
+	}
+
+	for _, f := range failures {
+		ledger.failures[f.key()] = f
+	}
+
+	return ledger, nil
+}
+
+func (ledger *Ledger) RecordFailure(day date.Date, photoID string, stage string, err error) {
+	ledger.mu.Lock()
+	defer ledger.mu.Unlock()
+
+	f := &Failure{PhotoID: photoID, Day: day.Format(input_layout)}
+
+	if existing := ledger.failures[f.key()]; existing != nil {
+		f = existing
+	}
+
+	f.Stage = stage
+	f.Error = err.Error()
+	f.Attempts++
+	f.LastAttempt = time.Now()
+
+	ledger.failures[f.key()] = f
+
+	if photoID != "" {
+		ledger.failed++
+	} else {
+		ledger.failedDays++
+	}
+}
+
+func (ledger *Ledger) RecordSuccess(photoID string) {
+	ledger.mu.Lock()
+	defer ledger.mu.Unlock()
+
+	delete(ledger.failures, photoID)
+	ledger.downloaded++
+}
+
+//clears a previous search failure for the day
+func (ledger *Ledger) RecordSearched(day date.Date) {
+	ledger.mu.Lock()
+	defer ledger.mu.Unlock()
+
+	delete(ledger.failures, "day:"+day.Format(input_layout))
+}
+
+func (ledger *Ledger) Failures() []*Failure {
+	ledger.mu.Lock()
+	defer ledger.mu.Unlock()
+
+	rv := make([]*Failure, 0, len(ledger.failures))
+
+	for _, f := range ledger.failures {
+		rv = append(rv, f)
+	}
+
+	sort.Sort(byDayAndID(rv))
+
+	return rv
+}
+
+func (ledger *Ledger) Save() error {
+	failures := ledger.Failures()
+
+	if len(failures) == 0 {
+		err := os.Remove(ledger.path)
+
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Trace(err)
+		}
+
+		return nil
+	}
+
+	asJson, err := json.MarshalIndent(failures, "", "  ")
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	err = ioutil.WriteFile(ledger.path, asJson, 0644)
+
+	if err != nil {
+		return errors.Annotatef(err, "Failed to write failure ledger %v", ledger.path)
+	}
+
+	return nil
+}
+
+//returns an error if anything in this run failed; failures left from earlier runs are only reported
+func (ledger *Ledger) Summarise() error {
+	failures := ledger.Failures()
+	days := 0
+
+	for _, f := range failures {
+		if f.PhotoID == "" {
+			days++
+		}
+	}
+
+	fmt.Printf("Downloaded %v photos, %v failed\n", ledger.downloaded, ledger.failed)
+
+	if len(failures) == 0 {
+		return nil
+	}
+
+	for _, f := range failures {
+		if f.PhotoID == "" {
+			logrus.Warnf("Day %v could not be searched: %v", f.Day, f.Error)
+		} else {
+			logrus.Warnf("Photo %v from %v failed at %v: %v", f.PhotoID, f.Day, f.Stage, f.Error)
+		}
+	}
+
+	fmt.Printf("%v photos and %v days are still pending in %v, run with --retry-failed to retry them\n", len(failures)-days, days, ledger.path)
+
+	if ledger.failed > 0 || ledger.failedDays > 0 {
+		return errors.Errorf("%v photos and %v days failed to download", ledger.failed, ledger.failedDays)
+	}
+
+	return nil
+}
+
+type byDayAndID []*Failure
+
+func (a byDayAndID) Len() int {
+	return len(a)
+}
+func (a byDayAndID) Swap(i, j int) {
+	a[i], a[j] = a[j], a[i]
+}
+func (a byDayAndID) Less(i, j int) bool {
+	if a[i].Day != a[j].Day {
+		return a[i].Day < a[j].Day
+	}
+	return a[i].PhotoID < a[j].PhotoID
+}
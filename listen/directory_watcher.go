@@ -1,39 +1,147 @@
 package listen
 
 import (
+	log "github.com/Sirupsen/logrus"
 	"github.com/fsnotify/fsnotify"
 	"github.com/jpg0/flickrup/config"
-	log "github.com/Sirupsen/logrus"
 	"github.com/juju/errors"
+	"os"
+	"path/filepath"
+	"strings"
 )
 
+//sends on the returned channel whenever a file in the watch roots changes, using change notifications or polling
 func Watch(cfg *config.Config, cm *ChangeManger) (<-chan struct {}, error){
+	filter, err := cfg.FileFilter()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	interval, err := cfg.PollInterval()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	poll := func() (<-chan struct{}, error) {
+		log.Infof("Polling for changes every %v", interval)
+		return NewPoller(cfg.WatchRoots(), cfg.WatchRecursive, filter, cm, interval).Start(), nil
+	}
+
+	switch cfg.Watching() {
+	case "poll":
+		return poll()
+	case "notify":
+		return watchNotify(cfg, filter, cm)
+	}
+
+	for _, root := range cfg.WatchRoots() {
+		if fs := remoteFilesystem(root); fs != "" {
+			log.Infof("%v is on %v, which may not deliver change notifications", root, fs)
+			return poll()
+		}
+	}
+
+	c, err := watchNotify(cfg, filter, cm)
+
+	if err != nil {
+		log.Warnf("Failed to watch for change notifications, polling instead: %v", err)
+		return poll()
+	}
+
+	return c, nil
+}
+
+func watchNotify(cfg *config.Config, filter *config.FileFilter, cm *ChangeManger) (<-chan struct {}, error){
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
 	c := make(chan struct{})
 
+	for _, root := range cfg.WatchRoots() {
+		if cfg.WatchRecursive {
+			err = addTree(watcher, root)
+		} else {
+			err = watcher.Add(root)
+		}
+
+		if err != nil {
+			watcher.Close()
+			return nil, errors.Trace(err)
+		}
+	}
+
 	go func() {
 		for {
 			select {
 			case e := <-watcher.Events:
-				if !cm.ChangeObserved(cfg.WatchDir, e.Name) {
+				newDir := false
+
+				//new subdirectories are watched as they appear, along with anything already moved into them
+				if cfg.WatchRecursive && e.Op&fsnotify.Create == fsnotify.Create {
+					if info, err := os.Stat(e.Name); err == nil && info.IsDir() {
+						newDir = true
+
+						if err := addTree(watcher, e.Name); err != nil {
+							log.Warnf("Failed to watch new directory %v: %v", e.Name, err)
+						}
+					}
+				}
+
+				if !newDir && !filter.Matches(e.Name) {
+					log.Debugf("Ignoring excluded file change: %v", e.Name)
+				} else if !cm.ChangeObserved(filepath.Dir(e.Name), e.Name) {
 					log.Debugf("Observed file change: %v", e.Name)
 					c <- struct{}{}
 				} else {
 					log.Debugf("Ignoring status file change: %v", e.Name)
 				}
 			case err := <-watcher.Errors:
-				log.Error("error:", err)
+				if err != fsnotify.ErrEventOverflow {
+					log.Error("error:", err)
+					continue
+				}
+
+				//changes were lost, so directories created meanwhile are picked up and every file is looked at again
+				log.Warnf("Change notifications overflowed, rescanning")
+
+				if cfg.WatchRecursive {
+					for _, root := range cfg.WatchRoots() {
+						if err := addTree(watcher, root); err != nil {
+							log.Warnf("Failed to rewatch %v: %v", root, err)
+						}
+					}
+				}
+
+				c <- struct{}{}
 			}
 		}
 	}()
 
-	err = watcher.Add(cfg.WatchDir)
-	if err != nil {
-		return nil, errors.Trace(err)
-	}
-
 	return c, nil
-}
\ No newline at end of file
+}
+
+func addTree(watcher *fsnotify.Watcher, root string) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() {
+			return nil
+		}
+
+		if path != root && IsHidden(path) {
+			return filepath.SkipDir
+		}
+
+		log.Debugf("Watching %v", path)
+
+		return watcher.Add(path)
+	})
+}
+
+//hidden directories, such as .dropbox.cache, are never searched for files
+func IsHidden(path string) bool {
+	return strings.HasPrefix(filepath.Base(path), ".")
+}
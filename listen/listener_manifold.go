@@ -1,6 +1,9 @@
 package listen
 
-import log "github.com/Sirupsen/logrus"
+import (
+	log "github.com/Sirupsen/logrus"
+	"time"
+)
 
 type Listener struct {
 	processing bool
@@ -20,16 +23,27 @@ const (
 	ProcessingComplete
 )
 
-func NewListener(triggers <-chan struct{}, completions <-chan struct{}) *Listener {
+//pings the systemd watchdog every watchdog interval, if not 0
+func NewListener(triggers <-chan struct{}, completions <-chan struct{}, watchdog time.Duration) *Listener {
 
 	begin := make(chan BeginEvent, 1)
 
 	l := &Listener{begin:begin}
 
+	var pings <-chan time.Time
+
+	if watchdog > 0 {
+		pings = time.NewTicker(watchdog).C
+	}
+
 	go func() {
 		defer close(l.begin)
 		for {
 			select {
+			case <-pings:
+				if err := SdNotify(SD_WATCHDOG); err != nil {
+					log.Warnf("Failed to ping the watchdog: %v", err)
+				}
 			case <-completions:
 				l.triggered(ProcessingComplete)
 			case <-triggers:
@@ -0,0 +1,147 @@
+package listen
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/jpg0/flickrup/config"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+//filesystem types which don't deliver change notifications reliably, from statfs(2)
+var remoteFilesystems = map[uint32]string{
+	0x6969:     "NFS",
+	0x517B:     "SMB",
+	0xFF534D42: "CIFS",
+	0xFE534D42: "SMB2",
+	0x65735546: "FUSE",
+}
+
+//lets tests control when polls happen
+type Clock interface {
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+//finds changes by listing the watch roots every interval, for filesystems where fsnotify misses them
+type Poller struct {
+	roots     []string
+	recursive bool
+	filter    *config.FileFilter
+	cm        *ChangeManger
+	interval  time.Duration
+	clock     Clock
+	seen      map[string]fileState
+}
+
+func NewPoller(roots []string, recursive bool, filter *config.FileFilter, cm *ChangeManger, interval time.Duration) *Poller {
+	return &Poller{
+		roots:     roots,
+		recursive: recursive,
+		filter:    filter,
+		cm:        cm,
+		interval:  interval,
+		clock:     realClock{},
+	}
+}
+
+//sends on the returned channel after each poll which finds a change, like Watch
+func (p *Poller) Start() <-chan struct{} {
+	c := make(chan struct{})
+	p.seen = p.scan()
+
+	go func() {
+		for {
+			<-p.clock.After(p.interval)
+
+			if p.poll() {
+				c <- struct{}{}
+			}
+		}
+	}()
+
+	return c
+}
+
+//whether any file was added, changed or removed since the last poll, other than those expected
+func (p *Poller) poll() bool {
+	current := p.scan()
+	changed := false
+
+	observe := func(path string) {
+		if !p.cm.ChangeObserved(filepath.Dir(path), path) {
+			log.Debugf("Observed file change: %v", path)
+			changed = true
+		}
+	}
+
+	for path, state := range current {
+		if previous, ok := p.seen[path]; !ok || previous.size != state.size || !previous.modTime.Equal(state.modTime) {
+			observe(path)
+		}
+	}
+
+	for path := range p.seen {
+		if _, ok := current[path]; !ok {
+			observe(path)
+		}
+	}
+
+	p.seen = current
+
+	return changed
+}
+
+func (p *Poller) scan() map[string]fileState {
+	files := make(map[string]fileState)
+
+	for _, root := range p.roots {
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if info.IsDir() {
+				if path != root && (!p.recursive || IsHidden(path)) {
+					return filepath.SkipDir
+				}
+
+				return nil
+			}
+
+			if p.filter.Matches(path) {
+				files[path] = fileState{size: info.Size(), modTime: info.ModTime()}
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			log.Warnf("Failed to poll %v: %v", root, err)
+		}
+	}
+
+	return files
+}
+
+//returns the name of the filesystem if dir is on one where notifications may not be delivered, or ""
+func remoteFilesystem(dir string) string {
+	var stat syscall.Statfs_t
+
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return ""
+	}
+
+	return remoteFilesystems[uint32(stat.Type)]
+}
@@ -0,0 +1,96 @@
+package listen
+
+import (
+	"github.com/jpg0/flickrup/config"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//hands each timer to the test, which decides when it fires
+type fakeClock struct {
+	timers chan chan time.Time
+}
+
+func (fc *fakeClock) After(d time.Duration) <-chan time.Time {
+	timer := make(chan time.Time)
+	fc.timers <- timer
+	return timer
+}
+
+func TestPollerTriggersOnChanges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "poller")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	existing := filepath.Join(dir, "existing.jpg")
+	ioutil.WriteFile(existing, []byte("photo"), 0644)
+
+	cfg := config.Default()
+	filter, err := cfg.FileFilter()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cm := NewChangeManager()
+	clock := &fakeClock{timers: make(chan chan time.Time)}
+	poller := NewPoller([]string{dir}, false, filter, cm, time.Minute)
+	poller.clock = clock
+	triggers := poller.Start()
+
+	//fires the pending timer, then reports whether the poll triggered a run before waiting again
+	tick := func() bool {
+		timer := <-clock.timers
+		timer <- time.Now()
+
+		select {
+		case <-triggers:
+			return true
+		case next := <-clock.timers:
+			go func() { clock.timers <- next }()
+			return false
+		}
+	}
+
+	if tick() {
+		t.Error("Expected no trigger without changes")
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "new.jpg"), []byte("photo"), 0644)
+
+	if !tick() {
+		t.Error("Expected a new file to trigger")
+	}
+
+	ioutil.WriteFile(existing, []byte("edited photo"), 0644)
+
+	if !tick() {
+		t.Error("Expected a changed file to trigger")
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "new.jpg.part"), []byte("partial"), 0644)
+
+	if tick() {
+		t.Error("Expected an excluded file not to trigger")
+	}
+
+	cm.Expect(existing)
+	ioutil.WriteFile(existing, []byte("rewritten photo"), 0644)
+
+	if tick() {
+		t.Error("Expected an expected change not to trigger")
+	}
+
+	os.Remove(existing)
+
+	if !tick() {
+		t.Error("Expected a removed file to trigger")
+	}
+}
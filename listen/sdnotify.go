@@ -0,0 +1,57 @@
+package listen
+
+import (
+	"github.com/juju/errors"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+//states sent to systemd for Type=notify services
+const (
+	SD_READY    = "READY=1"
+	SD_STOPPING = "STOPPING=1"
+	SD_WATCHDOG = "WATCHDOG=1"
+)
+
+//tells systemd the service state; does nothing when not run by systemd with a notify socket
+func SdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+
+	if socket == "" {
+		return nil
+	}
+
+	//abstract sockets are given with a leading @
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+
+	if err != nil {
+		return errors.Annotatef(err, "Failed to connect to systemd notify socket")
+	}
+
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+
+	return errors.Trace(err)
+}
+
+//how often to ping the systemd watchdog, half its timeout, or 0 if it is not enabled for this process
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond / 2
+}
@@ -0,0 +1,76 @@
+package listen
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSdNotify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sdnotify")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	os.Setenv("NOTIFY_SOCKET", socket)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+
+	err = SdNotify(SD_READY)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(buf[:n]) != SD_READY {
+		t.Errorf("Expected %v, got %v", SD_READY, string(buf[:n]))
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	defer os.Unsetenv("WATCHDOG_USEC")
+	defer os.Unsetenv("WATCHDOG_PID")
+
+	os.Unsetenv("WATCHDOG_USEC")
+
+	if interval := WatchdogInterval(); interval != 0 {
+		t.Errorf("Expected no watchdog, got %v", interval)
+	}
+
+	os.Setenv("WATCHDOG_USEC", "60000000")
+	os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+
+	if interval := WatchdogInterval(); interval != 30*time.Second {
+		t.Errorf("Expected 30s, got %v", interval)
+	}
+
+	//meant for another process
+	os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+
+	if interval := WatchdogInterval(); interval != 0 {
+		t.Errorf("Expected no watchdog for another pid, got %v", interval)
+	}
+}
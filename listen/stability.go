@@ -0,0 +1,223 @@
+package listen
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+//suffixes used by sync and download tools for files still being written
+var partialSuffixes = []string{".part", ".partial", ".crdownload", ".download", ".tmp", ".!sync"}
+
+//where Dropbox stages files before moving them into place
+const DROPBOX_CACHE = ".dropbox.cache"
+
+type observation struct {
+	size    int64
+	modTime time.Time
+	since   time.Time //when this size and mod time were first seen
+	polls   int
+}
+
+//tracks files across runs, deciding when each has stopped changing and can be uploaded
+type StabilityTracker struct {
+	window     time.Duration
+	polls      int
+	checkLocks bool
+	now        func() time.Time
+	observed   map[string]*observation
+}
+
+//a file is stable once its size and mod time are unchanged for the window, across at least polls checks
+//with checkLocks, a file is also held back while another process holds a lock on it
+func NewStabilityTracker(window time.Duration, polls int, checkLocks bool) *StabilityTracker {
+	if polls < 1 {
+		polls = 1
+	}
+
+	return &StabilityTracker{
+		window:     window,
+		polls:      polls,
+		checkLocks: checkLocks,
+		now:        time.Now,
+		observed:   make(map[string]*observation),
+	}
+}
+
+//a tracker for each watch root, as each run only retains the files in its own root
+type StabilityTrackers struct {
+	window     time.Duration
+	polls      int
+	checkLocks bool
+	now        func() time.Time
+	trackers   map[string]*StabilityTracker
+}
+
+func NewStabilityTrackers(window time.Duration, polls int, checkLocks bool) *StabilityTrackers {
+	if polls < 1 {
+		polls = 1
+	}
+
+	return &StabilityTrackers{
+		window:     window,
+		polls:      polls,
+		checkLocks: checkLocks,
+		now:        time.Now,
+		trackers:   make(map[string]*StabilityTracker),
+	}
+}
+
+func (sts *StabilityTrackers) For(watchDir string) *StabilityTracker {
+	tracker := sts.trackers[watchDir]
+
+	if tracker == nil {
+		tracker = NewStabilityTracker(sts.window, sts.polls, sts.checkLocks)
+		tracker.now = sts.now
+		sts.trackers[watchDir] = tracker
+	}
+
+	return tracker
+}
+
+//how long to wait before checking unstable files again
+func (sts *StabilityTrackers) Interval() time.Duration {
+	return sts.window / time.Duration(sts.polls)
+}
+
+//how long to wait before checking unstable files again
+func (st *StabilityTracker) Interval() time.Duration {
+	return st.window / time.Duration(st.polls)
+}
+
+//returns whether the file can be uploaded, or why not
+func (st *StabilityTracker) Ready(path string) (bool, string) {
+	if isPartial(path) {
+		return false, "it is a partial download"
+	}
+
+	info, err := os.Stat(path)
+
+	if err != nil {
+		delete(st.observed, path)
+		return false, err.Error()
+	}
+
+	now := st.now()
+	seen := st.observed[path]
+
+	if seen == nil || seen.size != info.Size() || !seen.modTime.Equal(info.ModTime()) {
+		st.observed[path] = &observation{
+			size:    info.Size(),
+			modTime: info.ModTime(),
+			since:   now,
+			polls:   1,
+		}
+
+		if seen != nil {
+			return false, "it changed since the last check"
+		}
+
+		//a backlog found at startup was last written before the window, so need not wait a whole window again
+		if now.Sub(info.ModTime()) < st.window {
+			return false, "it has not been checked before"
+		}
+	} else {
+		seen.polls++
+
+		if seen.polls < st.polls || now.Sub(seen.since) < st.window {
+			return false, "it has not been unchanged for long enough"
+		}
+	}
+
+	if st.checkLocks && locked(path) {
+		return false, "another process holds a lock on it"
+	}
+
+	if dropboxSyncing(filepath.Dir(path), now.Add(-st.Interval())) {
+		return false, "Dropbox is still syncing"
+	}
+
+	return true, ""
+}
+
+func (st *StabilityTracker) Forget(path string) {
+	delete(st.observed, path)
+}
+
+//drops files no longer being considered
+func (st *StabilityTracker) Retain(paths []string) {
+	keep := make(map[string]bool, len(paths))
+
+	for _, path := range paths {
+		keep[path] = true
+	}
+
+	for path := range st.observed {
+		if !keep[path] {
+			delete(st.observed, path)
+		}
+	}
+}
+
+func isPartial(path string) bool {
+	name := strings.ToLower(filepath.Base(path))
+
+	if strings.HasPrefix(name, "~$") || strings.HasPrefix(name, ".~") {
+		return true
+	}
+
+	for _, suffix := range partialSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+//whether another process holds a flock on the file, or it cannot be opened; locks are advisory,
+//so a writer which doesn't take one is not detected
+func locked(path string) bool {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return true
+	}
+
+	defer file.Close()
+
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+
+	if err != nil {
+		return true
+	}
+
+	syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+
+	return false
+}
+
+//whether Dropbox has staged files for dir, or one of its parents, since cutoff;
+//it writes them into place once complete, so until then they may be replaced
+func dropboxSyncing(dir string, cutoff time.Time) bool {
+	for dir = filepath.Clean(dir); ; dir = filepath.Dir(dir) {
+		entries, err := ioutil.ReadDir(filepath.Join(dir, DROPBOX_CACHE))
+
+		if err == nil {
+			for _, entry := range entries {
+				if entry.ModTime().After(cutoff) {
+					return true
+				}
+			}
+
+			return false
+		}
+
+		if filepath.Dir(dir) == dir {
+			return false
+		}
+	}
+}
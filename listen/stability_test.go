@@ -0,0 +1,139 @@
+package listen
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestFileStableAfterWindowAndPolls(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stability")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "photo.jpg")
+	ioutil.WriteFile(path, []byte("partly"), 0644)
+
+	now := time.Now()
+	tracker := NewStabilityTracker(time.Minute, 3, true)
+	tracker.now = func() time.Time { return now }
+
+	poll := func(expected bool) {
+		ready, reason := tracker.Ready(path)
+
+		if ready != expected {
+			t.Fatalf("Expected ready to be %v at %v, got %v (%v)", expected, now, ready, reason)
+		}
+
+		now = now.Add(tracker.Interval())
+	}
+
+	poll(false)
+	poll(false)
+
+	//still being written
+	ioutil.WriteFile(path, []byte("partly written"), 0644)
+
+	poll(false)
+	poll(false)
+	poll(false)
+	poll(true)
+}
+
+func TestPartialFilesNeverReady(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stability")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "photo.jpg.part")
+	ioutil.WriteFile(path, []byte("partly"), 0644)
+
+	tracker := NewStabilityTracker(0, 1, false)
+
+	for i := 0; i < 3; i++ {
+		if ready, _ := tracker.Ready(path); ready {
+			t.Fatal("Expected partial download not to be ready")
+		}
+	}
+}
+
+func TestTrackersKeepEachRootsObservations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stability")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	roots := []string{filepath.Join(dir, "a"), filepath.Join(dir, "b")}
+	now := time.Now()
+	trackers := NewStabilityTrackers(time.Minute, 3, false)
+	trackers.now = func() time.Time { return now }
+
+	var ready [2]bool
+
+	//each run retains only its own root's files, as PerformRun does
+	for poll := 0; poll < 4; poll++ {
+		for i, root := range roots {
+			os.MkdirAll(root, 0755)
+			path := filepath.Join(root, "photo.jpg")
+
+			if poll == 0 {
+				ioutil.WriteFile(path, []byte("photo"), 0644)
+			}
+
+			tracker := trackers.For(root)
+			tracker.Retain([]string{path})
+			ready[i], _ = tracker.Ready(path)
+		}
+
+		now = now.Add(trackers.Interval())
+	}
+
+	for i, root := range roots {
+		if !ready[i] {
+			t.Errorf("Expected the file in %v to be stable", root)
+		}
+	}
+}
+
+func TestBacklogReadyOnFirstSight(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stability")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "photo.jpg")
+	ioutil.WriteFile(path, []byte("photo"), 0644)
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(path, old, old)
+
+	tracker := NewStabilityTracker(time.Minute, 3, true)
+
+	if ready, reason := tracker.Ready(path); !ready {
+		t.Errorf("Expected a file last written before the window to be ready, got %v", reason)
+	}
+
+	file, _ := os.Open(path)
+	defer file.Close()
+	syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+
+	if ready, _ := tracker.Ready(path); ready {
+		t.Error("Expected a locked file not to be ready")
+	}
+}
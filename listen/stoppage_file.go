@@ -3,6 +3,7 @@ package listen
 import (
 	"os"
 	"io/ioutil"
+	"path/filepath"
 	"strings"
 	"fmt"
 	"github.com/Sirupsen/logrus"
@@ -48,7 +49,8 @@ func (us *UploadStatus) UpdateStatus(filename string) (err error) {
 }
 
 func (us *UploadStatus) WriteStatus(filename string) (err error) {
-	reader, err := os.Open(fmt.Sprintf("%v%v%v", us.dir, string(os.PathSeparator), filename))
+	//filename may be within a subdirectory of a recursively watched dir
+	reader, err := os.Open(filepath.Join(us.dir, filename))
 	if err != nil {
 		logrus.Error(err)
 	}
@@ -93,7 +95,7 @@ func (us *UploadStatus) WriteStatus(filename string) (err error) {
 	}
 	d.DrawString(filename)
 
-	fout, err := os.Create(fmt.Sprintf("%v%v%v", us.dir, string(os.PathSeparator), STATUS_FILE_PREFIX + filename))
+	fout, err := os.Create(fmt.Sprintf("%v%v%v", us.dir, string(os.PathSeparator), STATUS_FILE_PREFIX + filepath.Base(filename)))
 	if err != nil {
 		logrus.Error(err)
 		return
@@ -177,4 +179,21 @@ func (us *UploadStatus) ClearStatus() (err error) {
 	}
 
 	return
-}
\ No newline at end of file
+}
+
+//returns the name of the file that the uploader last stopped on, or "" if it is not stopped
+func (us *UploadStatus) StoppedOn() (string, error) {
+	files, err := ioutil.ReadDir(us.dir)
+
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+
+	for _, file := range files {
+		if strings.HasPrefix(file.Name(), STATUS_FILE_PREFIX) {
+			return strings.TrimPrefix(file.Name(), STATUS_FILE_PREFIX), nil
+		}
+	}
+
+	return "", nil
+}
@@ -1,14 +1,21 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/Sirupsen/logrus"
 	flickrdownconfig "github.com/jpg0/flickrdown/config"
+	flickrupconfig "github.com/jpg0/flickrup/config"
+	"github.com/jpg0/flickrup/journal"
+	"github.com/jpg0/flickrup/listen"
+	"github.com/jpg0/flickrdown/flickraccess"
+	"github.com/jpg0/flickrup/index"
 	"github.com/juju/errors"
 	"github.com/rickb777/date"
 	"github.com/urfave/cli"
 	"os"
 	"strings"
+	"time"
 )
 
 const input_layout string = "2006-01-02"
@@ -16,7 +23,7 @@ const input_layout string = "2006-01-02"
 func main() {
 	app := cli.NewApp()
 	app.Name = "flickrdown"
-	app.Usage = "Download photos from Flickr"
+	app.Usage = "Upload photos to and download photos from Flickr"
 	app.Version = "1.0"
 	app.Flags = []cli.Flag{
 		cli.StringFlag{
@@ -28,16 +35,190 @@ func main() {
 			Usage: "Logging level",
 			Value: "info",
 		},
+		cli.StringFlag{
+			Name:  "account",
+			Usage: "Named account(s) from the config to use, comma separated, or 'all' to download for every account",
+		},
 		cli.StringFlag{
 			Name:  "startdate",
-			Usage: "Date from which to begin processing",
+			Usage: "Date (yyyy-mm-dd) from which to begin processing",
 		},
 		cli.StringFlag{
 			Name:  "enddate",
-			Usage: "Date at which to complete processing",
+			Usage: "Date (yyyy-mm-dd) at which to complete processing, exclusive; defaults to the day after startdate",
+		},
+		cli.StringFlag{
+			Name:  "since",
+			Usage: "Process from this long ago until now, e.g. 7d, 2w, 3m or 1y",
+		},
+		cli.StringFlag{
+			Name:  "month",
+			Usage: "Process a calendar month (yyyy-mm)",
+		},
+		cli.StringFlag{
+			Name:  "year",
+			Usage: "Process a calendar year (yyyy)",
+		},
+		cli.BoolFlag{
+			Name:  "today",
+			Usage: "Process today",
+		},
+		cli.BoolFlag{
+			Name:  "yesterday",
+			Usage: "Process yesterday",
+		},
+		cli.StringFlag{
+			Name:  "timezone",
+			Usage: "Timezone in which days begin and end, e.g. Europe/London (overrides the config, defaults to UTC)",
+		},
+	}
+	app.Before = func(c *cli.Context) error {
+		return initLogging(c.GlobalString("loglevel"))
+	}
+	app.Action = verbose(func(c *cli.Context) error {
+		logrus.Warn("Running without a subcommand is deprecated, use 'download'")
+		return download(c)
+	})
+	app.Commands = []cli.Command{
+		{
+			Name:   "download",
+			Usage:  "Download photos uploaded between startdate and enddate into the archive",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "retry-failed",
+					Usage: "Only retry the photos and days recorded as failed by earlier downloads",
+				},
+				cli.BoolFlag{
+					Name:  "daemon",
+					Usage: "Keep running, syncing recently uploaded and updated photos on the download_schedule",
+				},
+			},
+			Action: verbose(download),
+		},
+		{
+			Name:   "watch",
+			Usage:  "Watch the watch dir and upload photos as they are tagged",
+			Action: verbose(watch),
+		},
+		{
+			Name:      "upload",
+			Usage:     "Upload the given files once, regardless of their tags",
+			ArgsUsage: "<files>",
+			Action:    verbose(upload),
+		},
+		{
+			Name:   "status",
+			Usage:  "Show the state of the uploader, the archive and the token for each account",
+			Action: verbose(status),
+		},
+		{
+			Name:  "verify",
+			Usage: "Compare the local archive with Flickr",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "report",
+					Usage: "File path to write the JSON report to (defaults to the archive dir)",
+				},
+				cli.BoolFlag{
+					Name:  "skipsize",
+					Usage: "Do not compare local file sizes with Flickr",
+				},
+			},
+			Action: verbose(verify),
+		},
+		{
+			Name:      "search",
+			Usage:     "Search the archive index, e.g. tags:beach year:2016 set:\"Holiday\"",
+			ArgsUsage: "<query>",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "rebuild",
+					Usage: "Rebuild the index from downloaded .meta files before searching",
+				},
+			},
+			Action: verbose(search),
+		},
+		{
+			Name:  "dedupe",
+			Usage: "Find identical files across the archive",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "action",
+					Usage: "What to do with duplicates: report, hardlink or quarantine",
+					Value: DEDUPE_REPORT,
+				},
+			},
+			Action: verbose(dedupe),
+		},
+		{
+			Name:  "similar",
+			Usage: "Group near-duplicate images and bursts in the archive",
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:  "distance",
+					Usage: "Largest perceptual hash distance (0-64) to treat as the same picture (defaults to the config)",
+				},
+				cli.DurationFlag{
+					Name:  "window",
+					Usage: "Only group images taken within this time of each other, e.g. 10s for bursts",
+				},
+			},
+			Action: verbose(findSimilar),
+		},
+		{
+			Name:  "config",
+			Usage: "Inspect the configuration",
+			Subcommands: []cli.Command{
+				{
+					Name:   "check",
+					Usage:  "Validate the config and print the effective settings with secrets masked",
+					Action: verbose(checkConfig),
+				},
+			},
+		},
+		{
+			Name:  "auth",
+			Usage: "Manage the Flickr token of an account",
+			Subcommands: []cli.Command{
+				{
+					Name:  "login",
+					Usage: "Authorise access to Flickr, replacing any stored token",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "listen",
+							Value: flickraccess.DEFAULT_LOGIN_LISTEN,
+							Usage: "Address to receive the authorisation callback on, must be reachable from the browser",
+						},
+						cli.BoolFlag{
+							Name:  "oob",
+							Usage: "Paste the verifier code instead of receiving a callback",
+						},
+						cli.StringFlag{
+							Name:  "perms",
+							Value: flickraccess.DEFAULT_LOGIN_PERMS,
+							Usage: "Permission to request: read, write or delete",
+						},
+						cli.DurationFlag{
+							Name:  "timeout",
+							Value: flickraccess.DEFAULT_LOGIN_TIMEOUT,
+							Usage: "How long to wait for authorisation",
+						},
+					},
+					Action: verbose(authLogin),
+				},
+				{
+					Name:   "status",
+					Usage:  "Check the stored token and report its permission level",
+					Action: verbose(authStatus),
+				},
+				{
+					Name:   "logout",
+					Usage:  "Remove the stored token",
+					Action: verbose(authLogout),
+				},
+			},
 		},
 	}
-	app.Action = verbose(watch)
 	err := app.Run(os.Args)
 
 	if err != nil {
@@ -77,45 +258,503 @@ func initLogging(level string) error {
 	return nil
 }
 
+func configFile(c *cli.Context) (string, error) {
+	configfile := c.GlobalString("config")
+
+	if configfile == "" {
+		return "", errors.New("No config file specified, use --config")
+	}
+
+	return configfile, nil
+}
+
+//loads the config for each account given, or the top level config if none are
+func loadAccountConfigs(c *cli.Context) ([]*flickrdownconfig.Config, error) {
+
+	configfile, err := configFile(c)
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	config, err := flickrdownconfig.Load(configfile)
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if c.GlobalString("timezone") != "" {
+		config.Timezone = c.GlobalString("timezone")
+	}
+
+	names := []string{""}
+
+	if c.GlobalString("account") == "all" {
+		names = config.AccountNames()
+
+		if len(names) == 0 {
+			return nil, errors.Errorf("--account all was given, but %v configures no accounts", configfile)
+		}
+	} else if c.GlobalString("account") != "" {
+		names = strings.Split(c.GlobalString("account"), ",")
+	}
+
+	rv := make([]*flickrdownconfig.Config, len(names))
+
+	for i, name := range names {
+		rv[i], err = config.ForAccount(strings.TrimSpace(name))
+
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+
+	return rv, nil
+}
+
+func loadConfig(c *cli.Context) (*flickrdownconfig.Config, error) {
+
+	configs, err := loadAccountConfigs(c)
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if len(configs) != 1 {
+		return nil, errors.Errorf("This command works with a single account, but --account %v names %v", c.GlobalString("account"), len(configs))
+	}
+
+	return configs[0], nil
+}
+
+//the uploader works with a single account
+func loadUploadConfig(c *cli.Context) (*flickrupconfig.Config, error) {
+
+	configfile, err := configFile(c)
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	config, err := flickrupconfig.Load(configfile)
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if strings.Contains(c.GlobalString("account"), ",") || c.GlobalString("account") == "all" {
+		return nil, errors.New("Uploading works with a single account")
+	}
+
+	return config.ForAccount(c.GlobalString("account"))
+}
+
+func download(c *cli.Context) error {
+
+	configs, err := loadAccountConfigs(c)
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	if c.Bool("daemon") {
+		return RunDownloadDaemon(configs)
+	}
+
+	failed := make([]string, 0)
+
+	for _, config := range configs {
+		if config.Account != "" {
+			logrus.Infof("Downloading for account %v into %v", config.Account, config.ArchiveDir)
+		}
+
+		if c.Bool("retry-failed") {
+			err = RetryFailedDownloads(config)
+		} else {
+			var startDate, endDate date.Date
+			var loc *time.Location
+
+			startDate, endDate, loc, err = dateRange(c, config, date.Date{})
+
+			if err != nil {
+				return errors.Trace(err)
+			}
+
+			logrus.Infof("Downloading photos uploaded from %v until %v (exclusive), %v", startDate, endDate, loc)
+
+			err = BeginBatchDownload(startDate, endDate, config)
+		}
+
+		//carry on with the other accounts
+		if err != nil {
+			logrus.Errorf("Failed to download for account %v: %v", config.Account, err)
+			failed = append(failed, config.Account)
+		}
+	}
+
+	if len(failed) > 0 && len(configs) == 1 {
+		return err
+	}
+
+	if len(failed) > 0 {
+		return errors.Errorf("Downloads failed for accounts: %v", strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
 func watch(c *cli.Context) error {
 
-	err := initLogging(c.String("loglevel"))
+	config, err := loadUploadConfig(c)
 
 	if err != nil {
 		return errors.Trace(err)
 	}
 
-	configfile := c.String("config")
+	if len(config.WatchRoots()) == 0 {
+		return errors.New("watch_dir or watch_dirs must be configured to watch")
+	}
 
-	if configfile == "" {
-		fmt.Println("No config file specified")
-		os.Exit(-1)
+	return CreateAndRunPipeline(config)
+}
+
+func upload(c *cli.Context) error {
+
+	if len(c.Args()) == 0 {
+		return errors.New("No files given to upload")
 	}
 
-	config, err := flickrdownconfig.Load(configfile)
+	config, err := loadUploadConfig(c)
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	processor, err := ProcessorPipeline(config)
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	preprocessor, err := PreprocessorPipeline(config)
 
 	if err != nil {
 		return errors.Trace(err)
 	}
 
-	var startDate date.Date
+	return UploadFiles(c.Args(), preprocessor, processor, config, listen.NewChangeManager())
+}
+
+func status(c *cli.Context) error {
+
+	configs, err := loadAccountConfigs(c)
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	for _, config := range configs {
+		if config.Account != "" {
+			fmt.Printf("Account %v\n", config.Account)
+		}
+
+		for _, profile := range config.WatchProfiles() {
+			fmt.Printf("  watching:  %v\n", profile.WatchDir)
+
+			stoppedOn, err := listen.NewUploadStatus(profile.WatchDir, nil).StoppedOn()
+
+			if err != nil {
+				fmt.Printf("  uploads:   unknown (%v)\n", err)
+			} else if stoppedOn != "" {
+				fmt.Printf("  uploads:   stopped on untagged %v\n", stoppedOn)
+			} else {
+				fmt.Printf("  uploads:   up to date\n")
+			}
+		}
+
+		if len(config.WatchRoots()) > 0 {
+			pending, err := journal.Read(config.JournalPath())
+
+			if err != nil {
+				fmt.Printf("  journal:   unknown (%v)\n", err)
+			} else if len(pending) > 0 {
+				fmt.Printf("  journal:   %v files to resume\n", len(pending))
+
+				for _, entry := range pending {
+					fmt.Printf("    %v, %v %v\n", entry.Path, entry.State, entry.UploadedId)
+				}
+			}
+		}
+
+		fmt.Printf("  archive:   %v\n", config.ArchiveDir)
+
+		idx, err := index.New(config.IndexPath())
+
+		if err != nil {
+			fmt.Printf("  indexed:   unknown (%v)\n", err)
+		} else {
+			count := 0
+			err = idx.ForEach(func(item *index.Item) error {
+				count++
+				return nil
+			})
+
+			if err != nil {
+				fmt.Printf("  indexed:   unknown (%v)\n", err)
+			} else {
+				fmt.Printf("  indexed:   %v items\n", count)
+			}
+		}
+
+		store, err := flickraccess.NewTokenStore(config)
 
-	if c.String("startdate") != "" {
-		startDate, err = date.Parse(input_layout, c.String("startdate"))
 		if err != nil {
 			return errors.Trace(err)
 		}
+
+		info, err := flickraccess.CheckToken(config.APIKey, config.SharedSecret, store)
+
+		if err != nil {
+			fmt.Printf("  token:     %v, not usable (%v)\n", store, err)
+		} else {
+			fmt.Printf("  token:     %v, %v access as %v\n", store, info.Perms, info.Username)
+		}
 	}
 
-	endDate := startDate.Add(1)
+	return nil
+}
+
+func verify(c *cli.Context) error {
+
+	config, err := loadConfig(c)
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	startDate, endDate, _, err := dateRange(c, config, minstart)
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	reportFile := c.String("report")
+
+	if reportFile == "" {
+		reportFile = config.ArchiveDir + "/" + VERIFY_REPORT_FILE
+	}
+
+	return VerifyArchive(startDate, endDate, config, reportFile, !c.Bool("skipsize"))
+}
+
+func search(c *cli.Context) error {
+
+	config, err := loadConfig(c)
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	idx, err := index.New(config.IndexPath())
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	if c.Bool("rebuild") {
+		err = RebuildIndex(config, idx)
+
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	return SearchIndex(idx, c.Args())
+}
+
+func dedupe(c *cli.Context) error {
+
+	config, err := loadConfig(c)
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	idx, err := index.New(config.IndexPath())
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	return Dedupe(config, idx, c.String("action"))
+}
+
+func findSimilar(c *cli.Context) error {
+
+	config, err := loadConfig(c)
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	distance := c.Int("distance")
+
+	if distance == 0 {
+		distance = config.NearDuplicateMaxDistance()
+	}
+
+	return FindSimilar(config.IndexPath(), distance, c.Duration("window"))
+}
+
+func authLogin(c *cli.Context) error {
+
+	config, err := loadConfig(c)
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	switch c.String("perms") {
+	case "read", "write", "delete":
+	default:
+		return errors.Errorf("Unknown permission %v, expected read, write or delete", c.String("perms"))
+	}
+
+	store, err := flickraccess.NewTokenStore(config)
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	token, err := flickraccess.Login(config.APIKey, config.SharedSecret, store, flickraccess.LoginOptions{
+		Listen:    c.String("listen"),
+		OutOfBand: c.Bool("oob"),
+		Perms:     c.String("perms"),
+		Timeout:   c.Duration("timeout"),
+	})
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	fmt.Printf("Logged in as %v, token stored in %v\n", token.Username, store)
+
+	return nil
+}
+
+func authStatus(c *cli.Context) error {
+
+	config, err := loadConfig(c)
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	store, err := flickraccess.NewTokenStore(config)
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	info, err := flickraccess.CheckToken(config.APIKey, config.SharedSecret, store)
+
+	if errors.IsNotFound(err) {
+		fmt.Printf("Not logged in, no token in %v\n", store)
+		return nil
+	}
+
+	if err != nil {
+		return errors.Annotate(err, "Failed to check token")
+	}
+
+	fmt.Printf("Token:       %v\n", store)
+	fmt.Printf("User:        %v (%v)\n", info.Username, info.Nsid)
+	fmt.Printf("Permissions: %v\n", info.Perms)
+
+	return nil
+}
+
+func authLogout(c *cli.Context) error {
+
+	config, err := loadConfig(c)
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	store, err := flickraccess.NewTokenStore(config)
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	err = flickraccess.Logout(store)
+
+	if errors.IsNotFound(err) {
+		fmt.Printf("Not logged in, no token in %v\n", store)
+		return nil
+	}
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	fmt.Printf("Removed token from %v. Revoke access at https://www.flickr.com/services/auth/list.gne to invalidate it on Flickr too.\n", store)
+
+	return nil
+}
+
+func checkConfig(c *cli.Context) error {
+
+	configfile, err := configFile(c)
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	config, err := flickrdownconfig.Read(configfile)
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	asJson, err := json.MarshalIndent(config.Masked(), "", "  ")
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	fmt.Printf("# %v (%v, with %v* and %v* environment overrides)\n", configfile, flickrdownconfig.Format(configfile), flickrdownconfig.EnvPrefixes[0], flickrdownconfig.EnvPrefixes[1])
+	fmt.Println(string(asJson))
+
+	for _, name := range append([]string{""}, config.AccountNames()...) {
+		account, err := config.ForAccount(name)
 
-	if c.String("enddate") != "" {
-		endDate, err = date.Parse(input_layout, c.String("enddate"))
 		if err != nil {
 			return errors.Trace(err)
 		}
+
+		if name != "" {
+			fmt.Printf("\nAccount %v\n", name)
+		} else {
+			fmt.Println()
+		}
+
+		fmt.Printf("  archive:     %v\n", account.ArchiveDir)
+		fmt.Printf("  index:       %v\n", account.IndexPath())
+		fmt.Printf("  quarantine:  %v\n", account.QuarantinePath())
+		fmt.Printf("  token:       %v (%v)\n", account.TokenPath(), account.TokenStoreType())
 	}
 
+	err = config.Validate()
 
-	return BeginBatchDownload(startDate, endDate, config)
+	if err != nil {
+		fmt.Println()
+		fmt.Println(err)
+		return errors.New("Config check failed")
+	}
+
+	fmt.Println("\nConfig is valid")
+
+	return nil
 }
@@ -5,6 +5,7 @@ package mock_flickr
 
 import (
 	gomock "github.com/golang/mock/gomock"
+	context "golang.org/x/net/context"
 	time "time"
 )
 
@@ -29,23 +30,23 @@ func (_m *MockSetClient) EXPECT() *_MockSetClientRecorder {
 	return _m.recorder
 }
 
-func (_m *MockSetClient) DateOfSet(setName string) (time.Time, error) {
-	ret := _m.ctrl.Call(_m, "DateOfSet", setName)
+func (_m *MockSetClient) DateOfSet(ctx context.Context, setName string) (time.Time, error) {
+	ret := _m.ctrl.Call(_m, "DateOfSet", ctx, setName)
 	ret0, _ := ret[0].(time.Time)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-func (_mr *_MockSetClientRecorder) DateOfSet(arg0 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "DateOfSet", arg0)
+func (_mr *_MockSetClientRecorder) DateOfSet(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "DateOfSet", arg0, arg1)
 }
 
-func (_m *MockSetClient) AddToSet(photoId string, setName string, datePhotoTaken time.Time) error {
-	ret := _m.ctrl.Call(_m, "AddToSet", photoId, setName, datePhotoTaken)
+func (_m *MockSetClient) AddToSet(ctx context.Context, photoId string, setName string, datePhotoTaken time.Time) error {
+	ret := _m.ctrl.Call(_m, "AddToSet", ctx, photoId, setName, datePhotoTaken)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-func (_mr *_MockSetClientRecorder) AddToSet(arg0, arg1, arg2 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "AddToSet", arg0, arg1, arg2)
+func (_mr *_MockSetClientRecorder) AddToSet(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "AddToSet", arg0, arg1, arg2, arg3)
 }
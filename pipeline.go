@@ -11,11 +11,17 @@ import (
 	log "github.com/Sirupsen/logrus"
 	"github.com/jpg0/flickrup/flickraccess"
 	"github.com/jpg0/flickrup/filetype"
+	"github.com/jpg0/flickrup/index"
+	"github.com/jpg0/flickrup/journal"
+	"github.com/jpg0/flickrup/similar"
+	"golang.org/x/net/context"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
-const WAIT_TIME = time.Minute * 5
-
+//watches and uploads until SIGTERM or SIGINT, then finishes the current file and returns; a second signal abandons it
 func CreateAndRunPipeline(config *config.Config) error {
 	//us := listen.NewUploadStatus(config.WatchDir)
 	cm := listen.NewChangeManager()
@@ -28,7 +34,7 @@ func CreateAndRunPipeline(config *config.Config) error {
 
 	completions := make(chan struct{})
 
-	l := listen.NewListener(listen.Coalesce(triggerChannel), completions)
+	l := listen.NewListener(listen.Coalesce(triggerChannel), completions, listen.WatchdogInterval())
 
 	processor, err := ProcessorPipeline(config)
 	if err != nil {
@@ -39,23 +45,88 @@ func CreateAndRunPipeline(config *config.Config) error {
 		return errors.Trace(err)
 	}
 
+	window, polls, err := config.Stability()
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	trackers := listen.NewStabilityTrackers(window, polls, config.StabilityExclusiveOpen)
+
+	stop := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(signals)
+
+	go func() {
+		s := <-signals
+		log.Infof("Received %v, finishing the current file", s)
+
+		if err := listen.SdNotify(listen.SD_STOPPING); err != nil {
+			log.Warnf("Failed to notify systemd of stopping: %v", err)
+		}
+
+		close(stop)
+
+		s = <-signals
+		log.Infof("Received %v again, abandoning the current file", s)
+		cancel()
+	}()
+
+	if err := listen.SdNotify(listen.SD_READY); err != nil {
+		log.Warnf("Failed to notify systemd of readiness: %v", err)
+	}
+
 	// initial run
 	log.Infof("Triggering initial run...")
 	l.TriggerNow()
 
 	for {
 		select {
+		case <-stop:
+			log.Info("Uploader stopped")
+			return nil
 		case beginEvent := <-l.BeginChannel():
 
 			pause := beginEvent.AfterPause
 
 			doRun:
 			if pause {
-				log.Infof("Waiting for 5 mins...")
-				time.Sleep(WAIT_TIME)
+				log.Infof("Waiting %v for files to stabilise...", trackers.Interval())
+
+				select {
+				case <-stop:
+					log.Info("Uploader stopped")
+					return nil
+				case <-time.After(trackers.Interval()):
+				}
+			}
+
+			result := RESULT_STANDARD
+
+			//each watch root runs with its own profile; a rerun of any takes precedence over a reschedule
+			for _, profile := range config.WatchProfiles() {
+				if stopped(stop) {
+					break
+				}
+
+				switch SafePerformRun(ctx, preprocessor, processor, profile, cm, trackers.For(profile.WatchDir), stop) {
+				case RESULT_RERUN:
+					result = RESULT_RERUN
+				case RESULT_RESCHEDULE:
+					if result != RESULT_RERUN {
+						result = RESULT_RESCHEDULE
+					}
+				}
 			}
 
-			result := SafePerformRun(preprocessor, processor, config, cm)
+			if stopped(stop) {
+				log.Info("Uploader stopped")
+				return nil
+			}
 
 			if result == RESULT_RERUN {
 				log.Infof("Rerunnning")
@@ -70,12 +141,23 @@ func CreateAndRunPipeline(config *config.Config) error {
 			completions <- struct {}{}
 		}
 	}
-
-	return nil
 }
 
 func ProcessorPipeline(config *flickrupconfig.Config, additionalStages ...processing.Stage) (processing.Processor, error) {
 
+	store, err := flickraccess.NewTokenStore(config)
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	//uploading and adding to sets both need write access
+	err = flickraccess.Preflight(config.APIKey, config.SharedSecret, store, flickraccess.PERMS_WRITE, "Uploading")
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
 	client, err := flickraccess.NewUploadClient(config)
 
 	if err != nil {
@@ -85,17 +167,68 @@ func ProcessorPipeline(config *flickrupconfig.Config, additionalStages ...proces
 	tagSetProcessor, err := tags.NewTagSetProcessor(config)
 	rewriter := tags.NewRewriter()
 
+	idx, err := index.New(config.IndexPath())
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	j, err := journal.Open(config.JournalPath())
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
 	wiredStages := []processing.Stage{
 		processing.AsStage(rewriter.MaybeRewrite),
 		processing.AsStage(tags.MaybeReplace),
 		processing.AsStage(tags.MaybeBlock),
 		processing.AsStage(tags.ExtractVisibility),
-		client.Stage(),
-		tagSetProcessor.Stage(),
-		processing.AsStage(archive.Archive),
-		filetype.SidecarStage(),
 	}
 
+	if config.NearDuplicates != "" {
+		checker, err := similar.NewDuplicateChecker(idx, config.NearDuplicates, config.NearDuplicateMaxDistance())
+
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+
+		wiredStages = append(wiredStages, checker.Stage())
+	}
+
+	if config.DuplicateUploads != "" {
+		var finder flickraccess.ChecksumFinder
+
+		if config.ChecksumTags {
+			finder, err = flickraccess.NewChecksumFinder(config)
+
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+		}
+
+		checker, err := similar.NewUploadedChecker(idx, finder, config.DuplicateUploads)
+
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+
+		wiredStages = append(wiredStages, checker.Stage())
+	}
+
+	//the journal hashes files after any rewriting, so that resumed files are recognised
+	wiredStages = append(wiredStages,
+		archive.NewReplacementFinder(idx).Stage(),
+		j.Stage(),
+		processing.Timeout(processing.STAGE_UPLOAD, client.Stage()),
+		j.Checkpoint(journal.STATE_UPLOADED),
+		processing.Timeout(processing.STAGE_SETS, tagSetProcessor.Stage()),
+		j.Checkpoint(journal.STATE_SETS_ADDED),
+		processing.Timeout(processing.STAGE_ARCHIVE, processing.AsStage(archive.NewArchiver(idx).Archive)),
+		j.Checkpoint(journal.STATE_ARCHIVED),
+		filetype.SidecarStage(),
+	)
+
 	return processing.Chain(
 		append(wiredStages, additionalStages...)...,
 	), nil
@@ -103,13 +236,13 @@ func ProcessorPipeline(config *flickrupconfig.Config, additionalStages ...proces
 
 func PreprocessorPipeline(config *flickrupconfig.Config, additionalStages ...processing.PreStage) (processing.Preprocessor, error) {
 	return processing.ChainPreStages(
-		filetype.VideoConversionStage(),
+		processing.PreTimeout(processing.STAGE_CONVERT, filetype.VideoConversionStage()),
 	), nil
 }
 
-func SafePerformRun(preprocessor processing.Preprocessor, processor processing.Processor, config *config.Config, cm *listen.ChangeManger) ProcessResult {
+func SafePerformRun(ctx context.Context, preprocessor processing.Preprocessor, processor processing.Processor, config *config.Config, cm *listen.ChangeManger, tracker *listen.StabilityTracker, stop <-chan struct{}) ProcessResult {
 
-	rerun, err := PerformRun(preprocessor, processor, config, cm)
+	rerun, err := PerformRun(ctx, preprocessor, processor, config, cm, tracker, stop)
 
 	if err != nil {
 		log.Errorf("Run failed: %v", err)
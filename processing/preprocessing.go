@@ -2,9 +2,11 @@ package processing
 
 import (
 	"github.com/jpg0/flickrup/config"
+	"golang.org/x/net/context"
 )
 
 type PreprocessingContext struct {
+	Context context.Context
 	Filepath string
 	Config *config.Config
 	RequiresRestart bool
@@ -13,6 +15,7 @@ type PreprocessingContext struct {
 
 func NewPreprocessingContext(config *config.Config, filepath string, changeSink ChangeSink) *PreprocessingContext {
 	return &PreprocessingContext{
+		Context: context.Background(),
 		Config: config,
 		Filepath: filepath,
 		RequiresRestart: false,
@@ -2,18 +2,25 @@ package processing
 
 import (
 	"github.com/jpg0/flickrup/config"
+	"golang.org/x/net/context"
 	"time"
 )
 
 type ProcessingContext struct {
+	Context context.Context //cancelled when the file should be abandoned, or the current stage has timed out
 	File TaggedFile
 	Visibilty string
 	Config *config.Config
 	ArchiveSubdir string
 	UploadedId string
+	ReplacingId string //an existing photo to upload the file over, rather than creating a new one
+	ReplacingPath string //the archived copy of the photo being replaced
+	SetsAdded []string
 	OverrideDateTaken time.Time
 	ArchivedAs string
 	FileUpdated bool
+	Checksum string
+	PerceptualHash string
 	changeSink ChangeSink
 }
 
@@ -27,6 +34,7 @@ func (pc ProcessingContext) DateTakenForArchive() time.Time {
 
 func NewProcessingContext(config *config.Config, file TaggedFile, changeSink ChangeSink) *ProcessingContext {
 	return &ProcessingContext{
+		Context: context.Background(),
 		Visibilty: "default",
 		Config: config,
 		File: file,
@@ -38,4 +46,29 @@ func NewProcessingContext(config *config.Config, file TaggedFile, changeSink Cha
 func (pc *ProcessingContext) ExpectChange() {
 	pc.changeSink.Expect(pc.File.Filepath())
 	pc.FileUpdated = true
-}
\ No newline at end of file
+}
+
+//the sets named by the file's tags, followed by any default sets for its watch dir
+func (pc *ProcessingContext) Sets() []string {
+	sets := make([]string, 0)
+
+	if pc.Config.TagsetPrefix != "" {
+		sets = append(sets, ValuesByPrefix(pc.File.Keywords(), pc.Config.TagsetPrefix)...)
+	}
+
+	for _, set := range pc.Config.DefaultSets {
+		found := false
+
+		for _, existing := range sets {
+			if existing == set {
+				found = true
+			}
+		}
+
+		if !found {
+			sets = append(sets, set)
+		}
+	}
+
+	return sets
+}
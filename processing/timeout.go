@@ -0,0 +1,81 @@
+package processing
+
+import (
+	"github.com/juju/errors"
+	"golang.org/x/net/context"
+	"time"
+)
+
+//names of the stages which can be given a timeout in stage_timeouts
+const (
+	STAGE_CONVERT = "convert"
+	STAGE_UPLOAD  = "upload"
+	STAGE_SETS    = "sets"
+	STAGE_ARCHIVE = "archive"
+)
+
+//cancels the stage's context once it has run for the timeout configured for name; the stages after it are not limited
+func Timeout(name string, stage Stage) Stage {
+	return func(ctx *ProcessingContext, next Processor) ProcessingResult {
+		timeout, _ := ctx.Config.StageTimeout(name)
+
+		if timeout == 0 {
+			return stage(ctx, next)
+		}
+
+		parent := ctx.Context
+		timed, cancel := context.WithTimeout(parent, timeout)
+		defer cancel()
+
+		ctx.Context = timed
+
+		result := stage(ctx, func(ctx *ProcessingContext) ProcessingResult {
+			ctx.Context = parent
+			return next(ctx)
+		})
+
+		if ctx.Context == timed {
+			ctx.Context = parent
+			return timedOut(result, timed, name, timeout)
+		}
+
+		return result
+	}
+}
+
+func PreTimeout(name string, stage PreStage) PreStage {
+	return func(ctx *PreprocessingContext, next Preprocessor) ProcessingResult {
+		timeout, _ := ctx.Config.StageTimeout(name)
+
+		if timeout == 0 {
+			return stage(ctx, next)
+		}
+
+		parent := ctx.Context
+		timed, cancel := context.WithTimeout(parent, timeout)
+		defer cancel()
+
+		ctx.Context = timed
+
+		result := stage(ctx, func(ctx *PreprocessingContext) ProcessingResult {
+			ctx.Context = parent
+			return next(ctx)
+		})
+
+		if ctx.Context == timed {
+			ctx.Context = parent
+			return timedOut(result, timed, name, timeout)
+		}
+
+		return result
+	}
+}
+
+//says so when the stage failed because it ran out of time
+func timedOut(result ProcessingResult, timed context.Context, name string, timeout time.Duration) ProcessingResult {
+	if result.ResultType == ErrorResult && timed.Err() == context.DeadlineExceeded {
+		return NewErrorResult(errors.Annotatef(result.Error, "%v stage timed out after %v", name, timeout))
+	}
+
+	return result
+}
@@ -0,0 +1,57 @@
+package processing
+
+import (
+	"github.com/jpg0/flickrup/config"
+	"strings"
+	"testing"
+)
+
+func TestTimeoutCancelsStage(t *testing.T) {
+	cfg := &config.Config{StageTimeouts: map[string]string{STAGE_UPLOAD: "10ms"}}
+
+	hung := func(ctx *ProcessingContext, next Processor) ProcessingResult {
+		<-ctx.Context.Done()
+		return NewErrorResult(ctx.Context.Err())
+	}
+
+	result := Chain(Timeout(STAGE_UPLOAD, hung))(NewProcessingContext(cfg, nil, nil))
+
+	if result.ResultType != ErrorResult || !strings.Contains(result.Error.Error(), "upload stage timed out after 10ms") {
+		t.Errorf("Expected the upload stage to time out, got %v", result.Error)
+	}
+}
+
+func TestTimeoutOnlyLimitsItsStage(t *testing.T) {
+	cfg := &config.Config{StageTimeouts: map[string]string{STAGE_UPLOAD: "1h"}}
+	ctx := NewProcessingContext(cfg, nil, nil)
+	parent := ctx.Context
+
+	var limited, after bool
+
+	processor := Chain(
+		Timeout(STAGE_UPLOAD, func(ctx *ProcessingContext, next Processor) ProcessingResult {
+			_, limited = ctx.Context.Deadline()
+			return next(ctx)
+		}),
+		AsStage(func(ctx *ProcessingContext) ProcessingResult {
+			_, after = ctx.Context.Deadline()
+			return NewSuccessResult()
+		}),
+	)
+
+	if result := processor(ctx); result.ResultType != SuccessResult {
+		t.Fatal(result.Error)
+	}
+
+	if !limited {
+		t.Error("Expected the upload stage to have a deadline")
+	}
+
+	if after {
+		t.Error("Expected the next stage not to have a deadline")
+	}
+
+	if ctx.Context != parent {
+		t.Error("Expected the original context to be restored")
+	}
+}
@@ -3,7 +3,7 @@ package main
 import (
 	"github.com/jpg0/flickrup/processing"
 	"github.com/jpg0/flickrup/config"
-	"io/ioutil"
+	"path/filepath"
 	"sort"
 	"github.com/jpg0/flickrup/filetype"
 	"github.com/juju/errors"
@@ -11,8 +11,8 @@ import (
 	"os"
 	"sync"
 	"time"
-	"fmt"
 	"github.com/jpg0/flickrup/listen"
+	"golang.org/x/net/context"
 )
 
 type ProcessResult int
@@ -67,12 +67,12 @@ func takeWhile(files []processing.TaggedFile, f func(processing.TaggedFile) bool
 	return rv
 }
 
-func PerformRun(preprocessor processing.Preprocessor, processor processing.Processor, config *config.Config, cm *listen.ChangeManger) (ProcessResult, error) {
+//stops between files once stop is closed, and abandons the current file once ctx is done
+func PerformRun(ctx context.Context, preprocessor processing.Preprocessor, processor processing.Processor, config *config.Config, cm *listen.ChangeManger, tracker *listen.StabilityTracker, stop <-chan struct{}) (ProcessResult, error) {
 
 	processRunTime := time.Now()
-	stableTime := processRunTime.Add(-WAIT_TIME)
 
-	fileInfos, err := ioutil.ReadDir(config.WatchDir)
+	paths, err := ListFiles(config)
 
 	if err != nil {
 		return RESULT_STANDARD, errors.Trace(err)
@@ -82,21 +82,27 @@ func PerformRun(preprocessor processing.Preprocessor, processor processing.Proce
 
 	restartAfterPreprocess := false
 
-	for _, toProcess := range fileInfos {
-		log.Debugf("Beginning preprocessing for %v", toProcess.Name())
-		ctx := processing.NewPreprocessingContext(config, config.WatchDir + "/" + toProcess.Name(), cm)
-		result = preprocessor(ctx)
+	for _, toProcess := range paths {
+		if stopped(stop) {
+			log.Infof("Stopping before preprocessing %v", toProcess)
+			return RESULT_STANDARD, nil
+		}
+
+		log.Debugf("Beginning preprocessing for %v", toProcess)
+		pctx := processing.NewPreprocessingContext(config, toProcess, cm)
+		pctx.Context = ctx
+		result = preprocessor(pctx)
 
-		restartAfterPreprocess = restartAfterPreprocess || ctx.RequiresRestart
+		restartAfterPreprocess = restartAfterPreprocess || pctx.RequiresRestart
 
 		switch result.ResultType {
 		case processing.SuccessResult:
-			log.Debugf("Preprocessing complete for %v", toProcess.Name())
+			log.Debugf("Preprocessing complete for %v", toProcess)
 		case processing.ErrorResult:
-			log.Warnf("Failed to preprocess %v", toProcess.Name())
+			log.Warnf("Failed to preprocess %v", toProcess)
 			log.Warn(result.Error)
 		case processing.RestartResult:
-			log.Infof("Restarting run after preprocessing %v", toProcess.Name())
+			log.Infof("Restarting run after preprocessing %v", toProcess)
 			return RESULT_RERUN, nil
 		}
 	}
@@ -106,65 +112,92 @@ func PerformRun(preprocessor processing.Preprocessor, processor processing.Proce
 		return RESULT_RERUN, nil
 	}
 
-	log.Infof("Preprocessed %v files", len(fileInfos))
+	log.Infof("Preprocessed %v files in %v", len(paths), config.WatchDir)
 
-	log.Infof("Processing %v files", len(fileInfos))
+	log.Infof("Processing %v files", len(paths))
 
-	files := LoadFiles(fileInfos, TaggedFileFactory(), config)
+	files := LoadFiles(paths, TaggedFileFactory())
 
 	log.Infof("Scanned %v files", len(files))
 
 	sort.Sort(ByDateTaken(files))
 
-	byDate := takeWhile(files, func(file processing.TaggedFile) bool {
-		return file.Keywords().All().Size() > 0
-	})
+	selection, err := SelectFiles(files, config, processRunTime)
+
+	if err != nil {
+		return RESULT_STANDARD, errors.Trace(err)
+	}
+
+	byDate := selection.Selected
+	stoppedOn := ""
+
+	if untagged := selection.StoppedOn(); untagged != nil {
+		stoppedOn = relativeName(config.WatchDir, untagged.Filepath())
+	}
 
 	if len(byDate) == 0 {
-		log.Info("No files selected for upload")
-		if len(files) > 0 {
-			log.Infof("Stopped on %v", files[0].Name())
-			UpdateStoppage(files[0].Name(), config, cm)
+		log.Infof("No files selected for upload: %v", selection.Summary())
+		if stoppedOn != "" {
+			log.Infof("Stopped on %v", stoppedOn)
+			UpdateStoppage(stoppedOn, config, cm)
 			return RESULT_STANDARD, nil
 		}
 	} else {
 		log.Infof("Selected %v files for upload", len(byDate))
 	}
 
-	for _, toProcess := range byDate {
-		log.Infof("Beginning processing for %v", toProcess.Name())
+	failed := 0
+	unstable := 0
 
-		fileInto, err := os.Stat(fmt.Sprintf("%v%v%v", config.WatchDir, string(os.PathSeparator), toProcess.Name()))
+	considered := make([]string, len(files))
 
-		log.Infof("%v > %v ? %v", fileInto.ModTime(), stableTime, fileInto.ModTime().After(stableTime))
+	for i, file := range files {
+		considered[i] = file.Filepath()
+	}
 
-		if err != nil {
-			log.Warnf("Failed to stat %v to check stabilised. Assuming stable.", toProcess.Name())
-		} else {
-			if fileInto.ModTime().After(stableTime) {
-				log.Infof("File %v recently changed, rescheduling", fileInto.Name())
-				return RESULT_RESCHEDULE, nil
-			}
+	tracker.Retain(considered)
+
+	for _, toProcess := range byDate {
+		if stopped(stop) {
+			log.Infof("Stopping before processing %v", toProcess.Name())
+			return RESULT_STANDARD, nil
+		}
+
+		//files still being written wait for a later run, without holding back the rest
+		if ready, reason := tracker.Ready(toProcess.Filepath()); !ready {
+			log.Infof("Not processing %v yet, as %v", toProcess.Name(), reason)
+			unstable++
+			continue
 		}
 
-		ctx := processing.NewProcessingContext(config, toProcess, cm)
-		result = processor(ctx)
+		log.Infof("Beginning processing for %v", toProcess.Name())
+
+		pctx := processing.NewProcessingContext(config, toProcess, cm)
+		pctx.Context = ctx
+		result = processor(pctx)
 
 		switch result.ResultType {
 		case processing.SuccessResult:
 			log.Infof("Processing complete for %v", toProcess.Name())
+			tracker.Forget(toProcess.Filepath())
 		case processing.ErrorResult:
 			log.Warnf("Failed to process %v", toProcess.Name())
 			log.Warn(result.Error)
+			failed++
 		case processing.RestartResult:
 			log.Infof("Restarting run after processing %v", toProcess.Name())
 			return RESULT_RERUN, nil
 		}
 	}
 
-	log.Infof("Processed %v files", len(byDate))
+	log.Infof("Processed %v files, %v failed, %v not yet stable: %v", len(byDate)-unstable, failed, unstable, selection.Summary())
 
-	UpdateStoppage("", config, nil)
+	//untagged files still holding back uploads remain the stoppage
+	UpdateStoppage(stoppedOn, config, cm)
+
+	if unstable > 0 {
+		return RESULT_RESCHEDULE, nil
+	}
 
 	return RESULT_STANDARD, nil
 }
@@ -185,11 +218,66 @@ func UpdateStoppage(filename string, config *config.Config, cm *listen.ChangeMan
 	}
 }
 
-func LoadFiles(files []os.FileInfo, factory *processing.TaggedFileFactory, config *config.Config) []processing.TaggedFile {
+//the files in the watch dir, and its subdirectories when watching recursively, skipping status files, hidden directories
+//and files the include and exclude patterns leave out
+func ListFiles(config *config.Config) ([]string, error) {
+	filter, err := config.FileFilter()
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	paths := make([]string, 0)
+
+	err = filepath.Walk(config.WatchDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			if path != config.WatchDir && (!config.WatchRecursive || listen.IsHidden(path)) {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if listen.IsStatusFile(config.WatchDir, path) {
+			return nil
+		}
+
+		if !filter.Matches(path) {
+			log.Debugf("Ignoring excluded file %v", path)
+			return nil
+		}
+
+		paths = append(paths, path)
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return paths, nil
+}
+
+func relativeName(dir string, path string) string {
+	rel, err := filepath.Rel(dir, path)
+
+	if err != nil {
+		return filepath.Base(path)
+	}
+
+	return rel
+}
+
+func LoadFiles(files []string, factory *processing.TaggedFileFactory) []processing.TaggedFile {
 
 	type Job struct {
 		Index int
-		Input os.FileInfo
+		Input string
 	}
 
 	processed := make([]processing.TaggedFile, len(files))
@@ -202,14 +290,14 @@ func LoadFiles(files []os.FileInfo, factory *processing.TaggedFileFactory, confi
 		wg.Add(1)
 		go func() {
 			for job := range tasks {
-				file, err := factory.LoadTaggedFile(config.WatchDir + "/" + job.Input.Name())
+				file, err := factory.LoadTaggedFile(job.Input)
 
 				if err != nil {
 					switch e := err.(type) {
 						case processing.NoConstructorAvailableError:
-							log.Debugf("Ignoring file %v", job.Input.Name())
+							log.Debugf("Ignoring file %v", job.Input)
 						default:
-							log.Warnf("Failed to load file %v, ignoring", job.Input.Name())
+							log.Warnf("Failed to load file %v, ignoring", job.Input)
 							log.Warnf(e.Error())
 					}
 
@@ -243,3 +331,61 @@ func LoadFiles(files []os.FileInfo, factory *processing.TaggedFileFactory, confi
 }
 
 
+
+//runs the pipeline once over the given files, in the order they were taken, regardless of their tags
+func UploadFiles(paths []string, preprocessor processing.Preprocessor, processor processing.Processor, config *config.Config, cm *listen.ChangeManger) error {
+	factory := TaggedFileFactory()
+	files := make([]processing.TaggedFile, 0)
+	failed := 0
+
+	for _, path := range paths {
+		ctx := processing.NewPreprocessingContext(config, path, cm)
+		result := preprocessor(ctx)
+
+		if result.ResultType == processing.ErrorResult {
+			log.Warnf("Failed to preprocess %v: %v", path, result.Error)
+			failed++
+			continue
+		}
+
+		if ctx.RequiresRestart || result.ResultType == processing.RestartResult {
+			log.Infof("Converted %v, upload the converted file instead", path)
+			continue
+		}
+
+		file, err := factory.LoadTaggedFile(path)
+
+		if err != nil {
+			log.Warnf("Failed to load %v: %v", path, err)
+			failed++
+			continue
+		}
+
+		files = append(files, file)
+	}
+
+	sort.Sort(ByDateTaken(files))
+
+	for _, file := range files {
+		log.Infof("Beginning processing for %v", file.Name())
+
+		result := processor(processing.NewProcessingContext(config, file, cm))
+
+		switch result.ResultType {
+		case processing.SuccessResult:
+			log.Infof("Processing complete for %v", file.Name())
+		case processing.ErrorResult:
+			log.Warnf("Failed to process %v: %v", file.Name(), result.Error)
+			failed++
+		case processing.RestartResult:
+			log.Warnf("Processing of %v asked for a restart, upload it again", file.Name())
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return errors.Errorf("%v of %v files failed", failed, len(paths))
+	}
+
+	return nil
+}
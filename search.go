@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/jpg0/flickrdown/config"
+	"github.com/jpg0/flickrup/index"
+	"github.com/juju/errors"
+	"sort"
+	"strings"
+)
+
+func SearchIndex(idx *index.Index, args []string) error {
+	query, err := index.ParseQuery(joinQueryArgs(args))
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	items, err := idx.Search(query)
+
+	if err != nil {
+		return errors.Annotate(err, "Failed to search index")
+	}
+
+	paths := make([]string, len(items))
+
+	for i, item := range items {
+		paths[i] = item.Path
+	}
+
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		fmt.Println(path)
+	}
+
+	logrus.Debugf("%v items matched", len(paths))
+
+	return nil
+}
+
+//the shell will have removed quotes, so restore them around values containing spaces
+func joinQueryArgs(args []string) string {
+	quoted := make([]string, len(args))
+
+	for i, arg := range args {
+		if strings.ContainsAny(arg, " \t") && !strings.Contains(arg, "\"") {
+			if j := strings.Index(arg, ":"); j > 0 {
+				arg = fmt.Sprintf("%v:\"%v\"", arg[:j], arg[j+1:])
+			} else {
+				arg = fmt.Sprintf("\"%v\"", arg)
+			}
+		}
+
+		quoted[i] = arg
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+//indexes everything downloaded, as recorded by .meta files
+func RebuildIndex(config *config.Config, idx *index.Index) error {
+	local, _, err := ScanArchive(config.ArchiveDir)
+
+	if err != nil {
+		return errors.Annotate(err, "Failed to scan archive")
+	}
+
+	count := 0
+
+	for _, lp := range local {
+		for _, path := range lp.Files {
+			err = idx.Put(indexItem(lp.Meta, path))
+
+			if err != nil {
+				return errors.Annotatef(err, "Failed to index %v", path)
+			}
+
+			count++
+		}
+	}
+
+	logrus.Infof("Indexed %v files", count)
+
+	return nil
+}
@@ -0,0 +1,9 @@
+package main
+
+import "testing"
+
+func TestJoinQueryArgs(t *testing.T) {
+	assertEquals(`tags:beach set:"Holiday 2016"`, joinQueryArgs([]string{"tags:beach", "set:Holiday 2016"}), t)
+	assertEquals(`"summer beach"`, joinQueryArgs([]string{"summer beach"}), t)
+	assertEquals(`set:"Holiday 2016"`, joinQueryArgs([]string{`set:"Holiday 2016"`}), t)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/jpg0/flickrup/config"
+	"github.com/jpg0/flickrup/processing"
+	"github.com/juju/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	POLICY_STRICT        = "strict"        //upload the oldest files up to the first untagged one
+	POLICY_SKIP_UNTAGGED = "skip_untagged" //upload every tagged file
+	POLICY_PER_FOLDER    = "per_folder"    //strict, but an untagged file only holds back its own folder
+	POLICY_DEADLINE      = "deadline"      //strict, but untagged files get the defaults once they have waited long enough
+)
+
+//in the archive dir, recording when untagged files arrived for the deadline policy
+const FIRST_SEEN_FILE = ".flickrup-first-seen.json"
+
+//the files that a run will upload, and those held back
+type Selection struct {
+	Policy    string
+	Selected  []processing.TaggedFile
+	Waiting   []processing.TaggedFile
+	Defaulted int
+}
+
+//files must be sorted by date taken
+func SelectFiles(files []processing.TaggedFile, config *config.Config, now time.Time) (*Selection, error) {
+	selection := &Selection{Policy: config.Policy()}
+
+	switch selection.Policy {
+	case POLICY_STRICT:
+		selection.Selected = takeWhile(files, tagged)
+		selection.Waiting = files[len(selection.Selected):]
+	case POLICY_SKIP_UNTAGGED:
+		for _, file := range files {
+			if tagged(file) {
+				selection.Selected = append(selection.Selected, file)
+			} else {
+				selection.Waiting = append(selection.Waiting, file)
+			}
+		}
+	case POLICY_PER_FOLDER:
+		byFolder := make(map[string][]processing.TaggedFile)
+
+		for _, file := range files {
+			dir := filepath.Dir(file.Filepath())
+			byFolder[dir] = append(byFolder[dir], file)
+		}
+
+		for _, inFolder := range byFolder {
+			selected := takeWhile(inFolder, tagged)
+			selection.Selected = append(selection.Selected, selected...)
+			selection.Waiting = append(selection.Waiting, inFolder[len(selected):]...)
+		}
+
+		sort.Stable(ByDateTaken(selection.Selected))
+		sort.Stable(ByDateTaken(selection.Waiting))
+	case POLICY_DEADLINE:
+		deadline, err := config.Deadline()
+
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+
+		defaults := config.DefaultTags
+
+		if config.DefaultVisibility != "" {
+			defaults = append(append([]string{}, defaults...), config.VisibilityPrefix+config.DefaultVisibility)
+		}
+
+		firstSeenPath := filepath.Join(config.ArchiveDir, FIRST_SEEN_FILE)
+		firstSeen, err := loadFirstSeen(firstSeenPath)
+
+		if err != nil {
+			log.Warnf("Deadlines will start again from now: %v", err)
+			firstSeen = make(map[string]time.Time)
+		}
+
+		//the file is shared by every watch dir archived here, so only this run's dir is pruned
+		stillUntagged := make(map[string]time.Time)
+
+		for path, seen := range firstSeen {
+			if !inWatchDir(path, config.WatchDir) {
+				stillUntagged[path] = seen
+			}
+		}
+
+		withDefaults := make([]processing.TaggedFile, len(files))
+
+		for i, file := range files {
+			withDefaults[i] = file
+
+			if tagged(file) {
+				continue
+			}
+
+			seen, ok := firstSeen[file.Filepath()]
+
+			if !ok {
+				seen = now
+			}
+
+			stillUntagged[file.Filepath()] = seen
+
+			if now.Sub(seen) > deadline {
+				log.Infof("%v has been untagged for more than %v, using the default tags %v", file.Name(), deadline, defaults)
+				withDefaults[i] = &defaultedFile{file, defaults}
+				selection.Defaulted++
+			}
+		}
+
+		//files in this watch dir which were tagged or removed are forgotten
+		err = saveFirstSeen(firstSeenPath, stillUntagged)
+
+		if err != nil {
+			log.Warnf("Failed to record when untagged files were first seen: %v", err)
+		}
+
+		selection.Selected = takeWhile(withDefaults, tagged)
+		selection.Waiting = withDefaults[len(selection.Selected):]
+	default:
+		return nil, errors.Errorf("Unknown selection policy: %v", selection.Policy)
+	}
+
+	return selection, nil
+}
+
+//when each untagged file was first seen; deadlines run from this rather than the mod time, which cameras and
+//Dropbox keep from when the photo was taken
+func loadFirstSeen(path string) (map[string]time.Time, error) {
+	firstSeen := make(map[string]time.Time)
+
+	data, err := ioutil.ReadFile(path)
+
+	if os.IsNotExist(err) {
+		return firstSeen, nil
+	}
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	err = json.Unmarshal(data, &firstSeen)
+
+	if err != nil {
+		return nil, errors.Annotatef(err, "Failed to read %v", path)
+	}
+
+	return firstSeen, nil
+}
+
+func saveFirstSeen(path string, firstSeen map[string]time.Time) error {
+	asJson, err := json.MarshalIndent(firstSeen, "", "  ")
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	return errors.Trace(ioutil.WriteFile(path, asJson, 0644))
+}
+
+func inWatchDir(path string, watchDir string) bool {
+	if watchDir == "" {
+		return true
+	}
+
+	rel, err := filepath.Rel(filepath.Clean(watchDir), filepath.Clean(path))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func tagged(file processing.TaggedFile) bool {
+	return file.Keywords().All().Size() > 0
+}
+
+//the first untagged file that is holding back uploads, or nil
+func (selection *Selection) StoppedOn() processing.TaggedFile {
+	for _, file := range selection.Waiting {
+		if !tagged(file) {
+			return file
+		}
+	}
+
+	return nil
+}
+
+func (selection *Selection) Summary() string {
+	summary := fmt.Sprintf("%v selection of %v", selection.Policy, plural(len(selection.Selected), "file"))
+
+	if selection.Defaulted > 0 {
+		summary += ", " + plural(selection.Defaulted, "file") + " given default tags"
+	}
+
+	if len(selection.Waiting) > 0 {
+		summary += ", " + plural(len(selection.Waiting), "file") + " waiting"
+
+		if stoppedOn := selection.StoppedOn(); stoppedOn != nil {
+			summary += ", the first untagged is " + stoppedOn.Name()
+		}
+	}
+
+	return summary
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+
+	return fmt.Sprintf("%v %vs", n, noun)
+}
+
+//an untagged file past the deadline, given the default tags
+type defaultedFile struct {
+	processing.TaggedFile
+	defaults []string
+}
+
+func (file *defaultedFile) Keywords() processing.Keywords {
+	return defaultedKeywords{file.TaggedFile.Keywords(), file.defaults}
+}
+
+type defaultedKeywords struct {
+	processing.Keywords
+	defaults []string
+}
+
+func (keywords defaultedKeywords) All() *processing.TagSet {
+	all := processing.NewTagSet(keywords.defaults)
+	all.AddAll(keywords.Keywords.All())
+	return all
+}
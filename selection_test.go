@@ -0,0 +1,163 @@
+package main
+
+import (
+	"github.com/jpg0/flickrup/config"
+	"github.com/jpg0/flickrup/processing"
+	"github.com/jpg0/flickrup/testlib"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func selectionFixture(now time.Time) []processing.TaggedFile {
+	file := func(path string, keywords []string, hoursAgo int) processing.TaggedFile {
+		return testlib.NewFakeTaggedFile(filepath.Base(path), path, make(map[string]string), keywords, now.Add(time.Duration(-hoursAgo)*time.Hour))
+	}
+
+	return []processing.TaggedFile{
+		file("/watch/a/1.jpg", []string{"beach"}, 4),
+		file("/watch/b/2.jpg", nil, 3),
+		file("/watch/a/3.jpg", []string{"sea"}, 2),
+		file("/watch/b/4.jpg", []string{"sand"}, 1),
+	}
+}
+
+func names(files []processing.TaggedFile) string {
+	rv := ""
+
+	for _, file := range files {
+		rv += file.Name() + " "
+	}
+
+	return rv
+}
+
+func TestSelectionPolicies(t *testing.T) {
+	now := time.Now()
+
+	for policy, expected := range map[string]string{
+		POLICY_STRICT:        "1.jpg ",
+		POLICY_SKIP_UNTAGGED: "1.jpg 3.jpg 4.jpg ",
+		POLICY_PER_FOLDER:    "1.jpg 3.jpg ",
+	} {
+		selection, err := SelectFiles(selectionFixture(now), &config.Config{SelectionPolicy: policy}, now)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assertEquals(expected, names(selection.Selected), t)
+
+		if stoppedOn := selection.StoppedOn(); stoppedOn == nil || stoppedOn.Name() != "2.jpg" {
+			t.Errorf("Expected %v selection to stop on 2.jpg, got %v", policy, stoppedOn)
+		}
+	}
+}
+
+func TestDeadlineGivesDefaultTags(t *testing.T) {
+	dir, err := ioutil.TempDir("", "selection")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	path := filepath.Join(dir, "2.jpg")
+	ioutil.WriteFile(path, []byte("untagged"), 0644)
+
+	files := selectionFixture(now)
+	files[1] = testlib.NewFakeTaggedFile("2.jpg", path, make(map[string]string), nil, now.Add(-3*time.Hour))
+
+	config := &config.Config{
+		ArchiveDir: dir,
+		SelectionPolicy: POLICY_DEADLINE,
+		SelectionDeadline: "72h",
+		DefaultTags: []string{"untagged"},
+		VisibilityPrefix: "visibility=",
+		DefaultVisibility: "private",
+	}
+
+	selection, err := SelectFiles(files, config, now)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertEquals("1.jpg ", names(selection.Selected), t)
+
+	//an old mod time, as kept by cameras and Dropbox, doesn't start the deadline early
+	os.Chtimes(path, now.Add(-100*time.Hour), now.Add(-100*time.Hour))
+
+	selection, err = SelectFiles(files, config, now.Add(time.Hour))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertEquals("1.jpg ", names(selection.Selected), t)
+
+	selection, err = SelectFiles(files, config, now.Add(73*time.Hour))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertEquals("1.jpg 2.jpg 3.jpg 4.jpg ", names(selection.Selected), t)
+
+	keywords := selection.Selected[1].Keywords().All()
+
+	if !keywords.Contains("untagged") || !keywords.Contains("visibility=private") {
+		t.Errorf("Expected default tags and visibility, got %v", keywords.Slice())
+	}
+
+	if selection.Defaulted != 1 {
+		t.Errorf("Expected 1 defaulted file, got %v", selection.Defaulted)
+	}
+}
+
+func TestDeadlineWatchDirsShareArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "selection")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	roots := []string{filepath.Join(dir, "a"), filepath.Join(dir, "b")}
+
+	profile := func(root string) *config.Config {
+		return &config.Config{
+			WatchDir: root,
+			ArchiveDir: dir,
+			SelectionPolicy: POLICY_DEADLINE,
+			SelectionDeadline: "72h",
+			DefaultTags: []string{"untagged"},
+		}
+	}
+
+	untagged := func(root string) []processing.TaggedFile {
+		path := filepath.Join(root, "photo.jpg")
+		return []processing.TaggedFile{testlib.NewFakeTaggedFile("photo.jpg", path, make(map[string]string), nil, now)}
+	}
+
+	//each root's run must keep the other root's first seen times
+	for _, at := range []time.Time{now, now.Add(time.Hour), now.Add(73 * time.Hour)} {
+		for _, root := range roots {
+			selection, err := SelectFiles(untagged(root), profile(root), at)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if at.Sub(now) > 72*time.Hour && selection.Defaulted != 1 {
+				t.Errorf("Expected the file in %v to be given default tags", root)
+			}
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/jpg0/flickrup/imagehash"
+	"github.com/jpg0/flickrup/index"
+	"github.com/jpg0/flickrup/similar"
+	"github.com/juju/errors"
+	"time"
+)
+
+//hashes any indexed images without a perceptual hash, then prints groups of near duplicates
+func FindSimilar(indexPath string, maxDistance int, window time.Duration) error {
+	idx, err := index.New(indexPath)
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	items := make([]*index.Item, 0)
+
+	err = idx.ForEach(func(item *index.Item) error {
+		items = append(items, item)
+		return nil
+	})
+
+	if err != nil {
+		return errors.Annotate(err, "Failed to read index")
+	}
+
+	for _, item := range items {
+		if item.PerceptualHash != "" || !imagehash.Supported(item.Path) {
+			continue
+		}
+
+		hash, err := imagehash.HashFile(item.Path)
+
+		if err != nil {
+			logrus.Warnf("Failed to compute perceptual hash of %v: %v", item.Path, err)
+			continue
+		}
+
+		item.PerceptualHash = imagehash.Format(hash)
+
+		err = idx.Put(item)
+
+		if err != nil {
+			return errors.Annotatef(err, "Failed to index %v", item.Path)
+		}
+	}
+
+	groups := similar.Group(items, maxDistance, window)
+
+	for _, group := range groups {
+		fmt.Printf("%v images taken %v to %v\n", len(group), group[0].DateTaken.Format(time.RFC3339), group[len(group)-1].DateTaken.Format(time.RFC3339))
+
+		for _, item := range group {
+			fmt.Printf("  %v %v\n", item.PerceptualHash, item.Path)
+		}
+	}
+
+	fmt.Printf("\nFound %v groups of similar images\n", len(groups))
+
+	return nil
+}
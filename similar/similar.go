@@ -0,0 +1,121 @@
+package similar
+
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/jpg0/flickrup/imagehash"
+	"github.com/jpg0/flickrup/index"
+	"sort"
+	"time"
+)
+
+//groups items whose perceptual hashes are within maxDistance of each other, transitively.
+//If window is non-zero, items must also have been taken within window of each other.
+func Group(items []*index.Item, maxDistance int, window time.Duration) [][]*index.Item {
+	hashed := make([]*index.Item, 0, len(items))
+	hashes := make([]uint64, 0, len(items))
+
+	for _, item := range items {
+		if item.PerceptualHash == "" {
+			continue
+		}
+
+		hash, err := imagehash.Parse(item.PerceptualHash)
+
+		if err != nil {
+			logrus.Warnf("Ignoring invalid perceptual hash for %v: %v", item.Path, err)
+			continue
+		}
+
+		hashed = append(hashed, item)
+		hashes = append(hashes, hash)
+	}
+
+	parent := make([]int, len(hashed))
+
+	for i := range parent {
+		parent[i] = i
+	}
+
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	for i := range hashed {
+		for j := i + 1; j < len(hashed); j++ {
+			if imagehash.Distance(hashes[i], hashes[j]) > maxDistance {
+				continue
+			}
+
+			if window > 0 && absDuration(hashed[i].DateTaken.Sub(hashed[j].DateTaken)) > window {
+				continue
+			}
+
+			parent[find(i)] = find(j)
+		}
+	}
+
+	byRoot := make(map[int][]*index.Item)
+
+	for i, item := range hashed {
+		root := find(i)
+		byRoot[root] = append(byRoot[root], item)
+	}
+
+	rv := make([][]*index.Item, 0)
+
+	for _, group := range byRoot {
+		if len(group) < 2 {
+			continue
+		}
+
+		sort.Slice(group, func(i, j int) bool {
+			return group[i].DateTaken.Before(group[j].DateTaken)
+		})
+
+		rv = append(rv, group)
+	}
+
+	sort.Slice(rv, func(i, j int) bool {
+		return rv[i][0].DateTaken.Before(rv[j][0].DateTaken)
+	})
+
+	return rv
+}
+
+//returns the closest uploaded item within maxDistance, or nil
+func ClosestUploaded(idx *index.Index, hash uint64, maxDistance int) (*index.Item, error) {
+	var closest *index.Item
+	closestDistance := maxDistance + 1
+
+	err := idx.ForEach(func(item *index.Item) error {
+		if item.PhotoID == "" || item.PerceptualHash == "" {
+			return nil
+		}
+
+		other, err := imagehash.Parse(item.PerceptualHash)
+
+		if err != nil {
+			return nil
+		}
+
+		if d := imagehash.Distance(hash, other); d < closestDistance {
+			closest = item
+			closestDistance = d
+		}
+
+		return nil
+	})
+
+	return closest, err
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
@@ -0,0 +1,40 @@
+package similar
+
+import (
+	"github.com/jpg0/flickrup/index"
+	"testing"
+	"time"
+)
+
+func item(path string, phash string, taken time.Time) *index.Item {
+	return &index.Item{Path: path, PerceptualHash: phash, DateTaken: taken}
+}
+
+func TestGroupNearDuplicates(t *testing.T) {
+	now := time.Now()
+
+	groups := Group([]*index.Item{
+		item("a", "ff00ff00ff00ff00", now),
+		item("b", "ff00ff00ff00ff01", now.Add(time.Second)),
+		item("c", "ff00ff00ff00ff03", now.Add(2*time.Second)),
+		item("d", "00ff00ff00ff00ff", now),
+		item("e", "", now),
+	}, 1, 0)
+
+	if len(groups) != 1 || len(groups[0]) != 3 {
+		t.Fatalf("Expected a, b and c to be grouped, got %v", groups)
+	}
+}
+
+func TestGroupWithinWindow(t *testing.T) {
+	now := time.Now()
+
+	groups := Group([]*index.Item{
+		item("a", "ff00ff00ff00ff00", now),
+		item("b", "ff00ff00ff00ff00", now.Add(time.Hour)),
+	}, 1, time.Minute)
+
+	if len(groups) != 0 {
+		t.Errorf("Expected no bursts, got %v", groups)
+	}
+}
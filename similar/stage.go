@@ -0,0 +1,68 @@
+package similar
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/jpg0/flickrup/imagehash"
+	"github.com/jpg0/flickrup/index"
+	"github.com/jpg0/flickrup/processing"
+	"github.com/juju/errors"
+)
+
+const (
+	MODE_WARN  = "warn"
+	MODE_BLOCK = "block"
+)
+
+type DuplicateChecker struct {
+	index       *index.Index
+	block       bool
+	maxDistance int
+}
+
+func NewDuplicateChecker(idx *index.Index, mode string, maxDistance int) (*DuplicateChecker, error) {
+	if mode != MODE_WARN && mode != MODE_BLOCK {
+		return nil, errors.Errorf("Unknown near duplicate mode: %v", mode)
+	}
+
+	return &DuplicateChecker{
+		index:       idx,
+		block:       mode == MODE_BLOCK,
+		maxDistance: maxDistance,
+	}, nil
+}
+
+//must occur before the upload stage
+func (dc *DuplicateChecker) Stage() processing.Stage {
+	return func(ctx *processing.ProcessingContext, next processing.Processor) processing.ProcessingResult {
+
+		if !imagehash.Supported(ctx.File.Filepath()) {
+			return next(ctx)
+		}
+
+		hash, err := imagehash.HashFile(ctx.File.Filepath())
+
+		if err != nil {
+			log.Warnf("Failed to compute perceptual hash of %v: %v", ctx.File.Name(), err)
+			return next(ctx)
+		}
+
+		ctx.PerceptualHash = imagehash.Format(hash)
+
+		match, err := ClosestUploaded(dc.index, hash, dc.maxDistance)
+
+		if err != nil {
+			log.Warnf("Failed to check %v for near duplicates: %v", ctx.File.Name(), err)
+			return next(ctx)
+		}
+
+		if match != nil {
+			if dc.block {
+				return processing.NewErrorResult(errors.Errorf("Blocking upload of %v as it is a near duplicate of %v (photo %v)", ctx.File.Name(), match.Path, match.PhotoID))
+			}
+
+			log.Warnf("%v is a near duplicate of %v (photo %v)", ctx.File.Name(), match.Path, match.PhotoID)
+		}
+
+		return next(ctx)
+	}
+}
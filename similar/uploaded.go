@@ -0,0 +1,103 @@
+package similar
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/jpg0/flickrup/flickraccess"
+	"github.com/jpg0/flickrup/index"
+	"github.com/jpg0/flickrup/processing"
+	"github.com/juju/errors"
+)
+
+const (
+	ACTION_SKIP   = "skip"   //archive the file without touching flickr
+	ACTION_ATTACH = "attach" //treat the existing photo as the upload, adding it to the file's sets
+)
+
+//finds files whose exact content was uploaded before
+type UploadedChecker struct {
+	index  *index.Index
+	finder flickraccess.ChecksumFinder
+	attach bool
+}
+
+//finder may be nil, in which case only the index is checked
+func NewUploadedChecker(idx *index.Index, finder flickraccess.ChecksumFinder, action string) (*UploadedChecker, error) {
+	if action != ACTION_SKIP && action != ACTION_ATTACH {
+		return nil, errors.Errorf("Unknown duplicate upload action: %v", action)
+	}
+
+	return &UploadedChecker{
+		index:  idx,
+		finder: finder,
+		attach: action == ACTION_ATTACH,
+	}, nil
+}
+
+//must occur before the upload stage; a match is given as the UploadedId, so that uploading is skipped
+func (uc *UploadedChecker) Stage() processing.Stage {
+	return func(ctx *processing.ProcessingContext, next processing.Processor) processing.ProcessingResult {
+
+		//already matched by an earlier stage
+		if ctx.UploadedId != "" {
+			return next(ctx)
+		}
+
+		id, err := uc.find(ctx)
+
+		if err != nil {
+			log.Warnf("Failed to check whether %v was already uploaded: %v", ctx.File.Name(), err)
+			return next(ctx)
+		}
+
+		if id == "" {
+			return next(ctx)
+		}
+
+		ctx.UploadedId = id
+
+		if uc.attach {
+			log.Infof("%v was already uploaded as %v, attaching to it rather than uploading again", ctx.File.Name(), id)
+		} else {
+			log.Infof("%v was already uploaded as %v, archiving without uploading", ctx.File.Name(), id)
+			ctx.SetsAdded = ctx.Sets()
+		}
+
+		return next(ctx)
+	}
+}
+
+func (uc *UploadedChecker) find(ctx *processing.ProcessingContext) (string, error) {
+	if ctx.Checksum == "" {
+		checksum, err := index.HashFile(ctx.File.Filepath())
+
+		if err != nil {
+			return "", errors.Trace(err)
+		}
+
+		ctx.Checksum = checksum
+	}
+
+	if uc.index != nil {
+		item, err := uc.index.ByChecksum(ctx.Checksum)
+
+		if err != nil {
+			return "", errors.Trace(err)
+		}
+
+		if item != nil {
+			return item.PhotoID, nil
+		}
+	}
+
+	if uc.finder == nil {
+		return "", nil
+	}
+
+	sha1, err := flickraccess.SHA1File(ctx.File.Filepath())
+
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+
+	return uc.finder.FindByChecksum(sha1)
+}
@@ -0,0 +1,94 @@
+package similar
+
+import (
+	"github.com/golang/mock/gomock"
+	"github.com/jpg0/flickrup/config"
+	"github.com/jpg0/flickrup/index"
+	"github.com/jpg0/flickrup/mocks"
+	"github.com/jpg0/flickrup/processing"
+	"github.com/jpg0/flickrup/testlib"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func uploadedFixture(t *testing.T) (string, *index.Index, processing.TaggedFile) {
+	dir, err := ioutil.TempDir("", "similar")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idx, err := index.New(filepath.Join(dir, "index.db"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "photo.jpg")
+
+	err = ioutil.WriteFile(path, []byte("content"), 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := testlib.NewFakeTaggedFile("photo.jpg", path, make(map[string]string), []string{"set=Holiday"}, time.Time{})
+
+	return dir, idx, file
+}
+
+func TestSkipUploadedInIndex(t *testing.T) {
+	dir, idx, file := uploadedFixture(t)
+	defer os.RemoveAll(dir)
+
+	checksum, _ := index.HashFile(file.Filepath())
+	idx.Put(&index.Item{PhotoID: "123", Path: "/archive/photo.jpg", Checksum: checksum})
+
+	checker, err := NewUploadedChecker(idx, nil, ACTION_SKIP)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := processing.NewProcessingContext(&config.Config{TagsetPrefix: "set="}, file, nil)
+	checker.Stage()(ctx, processing.SuccessProcessor)
+
+	if ctx.UploadedId != "123" {
+		t.Errorf("Expected existing photo to be used, got '%v'", ctx.UploadedId)
+	}
+
+	if len(ctx.SetsAdded) != 1 || ctx.SetsAdded[0] != "Holiday" {
+		t.Errorf("Expected sets not to be added when skipping, got %v", ctx.SetsAdded)
+	}
+}
+
+func TestAttachUploadedOnFlickr(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	dir, idx, file := uploadedFixture(t)
+	defer os.RemoveAll(dir)
+
+	finder := mock_flickr.NewMockChecksumFinder(mockCtrl)
+	finder.EXPECT().FindByChecksum("040f06fd774092478d450774f5ba30c5da78acc8").Return("456", nil)
+
+	checker, err := NewUploadedChecker(idx, finder, ACTION_ATTACH)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := processing.NewProcessingContext(&config.Config{TagsetPrefix: "set="}, file, nil)
+	checker.Stage()(ctx, processing.SuccessProcessor)
+
+	if ctx.UploadedId != "456" {
+		t.Errorf("Expected photo found on flickr to be used, got '%v'", ctx.UploadedId)
+	}
+
+	if len(ctx.SetsAdded) != 0 {
+		t.Errorf("Expected sets to be added when attaching, got %v", ctx.SetsAdded)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/Sirupsen/logrus"
+	"github.com/jpg0/flickrdown/config"
+	"github.com/jpg0/flickrdown/flickraccess"
+	"github.com/juju/errors"
+	"github.com/rickb777/date"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const SYNC_STATE_FILE = ".flickrdown-sync.json"
+
+//each sync repeats the end of the previous window, in case flickr was slow to report changes
+const SYNC_OVERLAP = time.Hour
+
+//failures are retried by each sync until they have been attempted this many times
+const MAX_SYNC_ATTEMPTS = 5
+
+type SyncState struct {
+	LastSync time.Time `json:"last_sync"`
+}
+
+func loadSyncState(path string) (*SyncState, error) {
+	state := &SyncState{}
+
+	data, err := ioutil.ReadFile(path)
+
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	err = json.Unmarshal(data, state)
+
+	if err != nil {
+		return nil, errors.Annotatef(err, "Failed to read sync state %v", path)
+	}
+
+	return state, nil
+}
+
+func (state *SyncState) save(path string) error {
+	asJson, err := json.MarshalIndent(state, "", "  ")
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	return errors.Trace(ioutil.WriteFile(path, asJson, 0644))
+}
+
+//syncs every account on the configured schedule until SIGTERM or SIGINT, finishing in-flight files before returning
+func RunDownloadDaemon(configs []*config.Config) error {
+
+	schedule, err := configs[0].Schedule()
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(signals)
+
+	go func() {
+		s := <-signals
+		logrus.Infof("Received %v, finishing in-flight downloads", s)
+		close(stop)
+	}()
+
+	for {
+		for _, config := range configs {
+			if stopped(stop) {
+				break
+			}
+
+			if config.Account != "" {
+				logrus.Infof("Syncing account %v into %v", config.Account, config.ArchiveDir)
+			}
+
+			err = SyncRecent(config, stop)
+
+			if err != nil {
+				logrus.Errorf("Sync failed for account %v: %v", config.Account, err)
+			}
+		}
+
+		next := schedule.Next(time.Now())
+		logrus.Infof("Next sync at %v", next)
+
+		select {
+		case <-stop:
+			logrus.Info("Download daemon stopped")
+			return nil
+		case <-time.After(next.Sub(time.Now())):
+		}
+	}
+}
+
+func stopped(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
+
+//downloads photos uploaded or updated since the last sync, and retries earlier failures
+func SyncRecent(config *config.Config, stop <-chan struct{}) error {
+
+	started := time.Now()
+	statePath := filepath.Join(config.ArchiveDir, SYNC_STATE_FILE)
+
+	state, err := loadSyncState(statePath)
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	since := state.LastSync.Add(-SYNC_OVERLAP)
+
+	if state.LastSync.IsZero() {
+		lookback, err := config.Lookback()
+
+		if err != nil {
+			return errors.Trace(err)
+		}
+
+		since = started.Add(-lookback)
+	}
+
+	ctx, err := buildContext(config)
+
+	if err != nil {
+		return errors.Annotate(err, "Failed to build context")
+	}
+
+	ctx.stop = stop
+
+	loc, err := config.Location()
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	retryFailures(ctx, MAX_SYNC_ATTEMPTS)
+
+	today := date.TodayIn(loc)
+
+	//newly uploaded photos, by min_upload_date; failed days and photos are retried from the ledger
+	for day := date.NewAt(since.In(loc)); !day.After(today) && !ctx.stopping(); day = day.Add(1) {
+		err = DownloadForDay(day, ctx)
+
+		if err != nil && err != errStopped {
+			logrus.Errorf("Failed to download for day %v: %v", day, err)
+		}
+	}
+
+	//photos uploaded earlier whose metadata or content has since changed, by lastupdate
+	err = syncUpdated(ctx, since, today)
+
+	if err != nil && err != errStopped {
+		logrus.Errorf("Failed to sync updated photos: %v", err)
+	}
+
+	complete := err == nil
+
+	//leave the window where it was so that the next sync covers anything missed
+	if complete && !ctx.stopping() {
+		state.LastSync = started
+		err = state.save(statePath)
+
+		if err != nil {
+			logrus.Errorf("Failed to save sync state %v: %v", statePath, err)
+		}
+	}
+
+	err = finishBatch(ctx)
+
+	if ctx.stopping() {
+		return errStopped
+	}
+
+	return err
+}
+
+func syncUpdated(ctx *DownloadingContext, since time.Time, today date.Date) error {
+
+	logrus.Infof("Checking for photos updated since %v", since)
+
+	batch := ctx.source.UpdatedSince(since)
+
+	for !ctx.stopping() {
+		photo, err := batch.NextPhoto()
+
+		if err != nil {
+			return errors.Trace(err)
+		}
+
+		if photo == nil {
+			return nil
+		}
+
+		if upToDate(ctx, photo) {
+			continue
+		}
+
+		logrus.Infof("Photo %v has changed since it was downloaded", photo.ID())
+
+		err = processPhoto(photo, today, ctx)
+
+		if err != nil {
+			logrus.Errorf("Failed to download updated photo %v: %v", photo.ID(), err)
+		}
+	}
+
+	return errStopped
+}
+
+//whether the archived metadata matches flickr's last update of the photo
+func upToDate(ctx *DownloadingContext, photo flickraccess.RemotePhoto) bool {
+	if ctx.index == nil {
+		return false
+	}
+
+	item, err := ctx.index.ByPhotoID(photo.ID())
+
+	if err != nil || item == nil {
+		return false
+	}
+
+	local, err := loadMeta(strings.TrimSuffix(item.Path, filepath.Ext(item.Path)) + ".meta")
+
+	if err != nil {
+		return false
+	}
+
+	remote, err := photo.GetMeta()
+
+	if err != nil {
+		//downloading will report the failure
+		return false
+	}
+
+	return local.Dates.LastUpdate == remote.Dates.LastUpdate
+}
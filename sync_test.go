@@ -0,0 +1,74 @@
+package main
+
+import (
+	"github.com/golang/mock/gomock"
+	"github.com/jpg0/flickrdown/mocks"
+	"github.com/rickb777/date"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyncUpdatedDownloadsChangedPhotos(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	source := mock_flickr.NewMockSearchSource(mockCtrl)
+	batch := mock_flickr.NewMockPhotoBatch(mockCtrl)
+	photo := mock_flickr.NewMockRemotePhoto(mockCtrl)
+	fetcher := mock_flickr.NewMockContentFetcher(mockCtrl)
+
+	ctx := newTestContext(t, source, fetcher)
+	defer os.RemoveAll(ctx.config.ArchiveDir)
+
+	since := time.Date(2016, 5, 4, 0, 0, 0, 0, time.UTC)
+
+	source.EXPECT().UpdatedSince(since).Return(batch)
+	gomock.InOrder(
+		batch.EXPECT().NextPhoto().Return(photo, nil),
+		batch.EXPECT().NextPhoto().Return(nil, nil),
+	)
+	photo.EXPECT().ID().Return("123").AnyTimes()
+	photo.EXPECT().GetMeta().Return(newTestMeta(t), nil).AnyTimes()
+	photo.EXPECT().GetLocation().Return(nil, nil).AnyTimes()
+	fetcher.EXPECT().Fetch(gomock.Any()).Return(ioutil.NopCloser(strings.NewReader("content")), nil)
+
+	err := syncUpdated(ctx, since, date.New(2016, 5, 4))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(ctx.config.ArchiveDir + "/2016/05/Holiday/beach.png")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertEquals("content", string(content), t)
+}
+
+func TestSyncUpdatedStartsNothingWhenStopping(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	source := mock_flickr.NewMockSearchSource(mockCtrl)
+	batch := mock_flickr.NewMockPhotoBatch(mockCtrl)
+
+	ctx := newTestContext(t, source, mock_flickr.NewMockContentFetcher(mockCtrl))
+	defer os.RemoveAll(ctx.config.ArchiveDir)
+
+	stop := make(chan struct{})
+	close(stop)
+	ctx.stop = stop
+
+	source.EXPECT().UpdatedSince(gomock.Any()).Return(batch)
+
+	err := syncUpdated(ctx, time.Now(), date.Today())
+
+	if err != errStopped {
+		t.Errorf("Expected sync to report it was stopped, got %v", err)
+	}
+}
@@ -13,7 +13,13 @@ type TagSetProcessor struct {
 
 func NewTagSetProcessor(config *config.Config) (*TagSetProcessor, error) {
 
-	setClient, err := flickraccess.NewFlickrSetClient(config.APIKey, config.SharedSecret)
+	store, err := flickraccess.NewTokenStore(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	setClient, err := flickraccess.NewFlickrSetClient(config.APIKey, config.SharedSecret, store)
 
 	if err != nil {
 		return nil, err
@@ -28,7 +34,7 @@ func NewTagSetProcessor(config *config.Config) (*TagSetProcessor, error) {
 func (tsp *TagSetProcessor) Stage() processing.Stage {
 	return func(ctx *processing.ProcessingContext, next processing.Processor) processing.ProcessingResult {
 
-		sets := processing.ValuesByPrefix(ctx.File.Keywords(), ctx.Config.TagsetPrefix)
+		sets := ctx.Sets()
 
 		if ctx.Visibilty != "offline" {
 			if len(sets) > 0 {
@@ -37,16 +43,23 @@ func (tsp *TagSetProcessor) Stage() processing.Stage {
 
 
 				for _, set := range sets {
+					if contains(ctx.SetsAdded, set) {
+						log.Infof("%v was already added to set: %v", ctx.File.Name(), set)
+						continue
+					}
+
 					log.Infof("Adding %v to set: %v", ctx.File.Name(), set)
-					err := tsp.setClient.AddToSet(ctx.UploadedId, set, ctx.File.DateTaken())
+					err := tsp.setClient.AddToSet(ctx.Context, ctx.UploadedId, set, ctx.File.DateTaken())
 
 					if err != nil {
 						return processing.NewErrorResult(errors.Annotate(err, "Adding photo to set"))
 					}
+
+					ctx.SetsAdded = append(ctx.SetsAdded, set)
 				}
 
 				ctx.ArchiveSubdir = sets[0]
-				date, err := tsp.setClient.DateOfSet(sets[0])
+				date, err := tsp.setClient.DateOfSet(ctx.Context, sets[0])
 
 				if err != nil {
 					return processing.NewErrorResult(errors.Annotate(err, "Getting date of set"))
@@ -58,4 +71,14 @@ func (tsp *TagSetProcessor) Stage() processing.Stage {
 
 		return next(ctx)
 	}
-}
\ No newline at end of file
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
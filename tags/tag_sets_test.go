@@ -50,8 +50,8 @@ func TestAddToSetRequired(t *testing.T){
 
 	ctx.UploadedId = "test_id"
 
-	mockObj.EXPECT().DateOfSet("X").Times(1).Return(setTime, nil)
-	mockObj.EXPECT().AddToSet(ctx.UploadedId, "X", fileTime)
+	mockObj.EXPECT().DateOfSet(gomock.Any(), "X").Times(1).Return(setTime, nil)
+	mockObj.EXPECT().AddToSet(gomock.Any(), ctx.UploadedId, "X", fileTime)
 
 	tsp := &TagSetProcessor{
 		setClient: mockObj,
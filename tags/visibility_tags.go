@@ -7,10 +7,16 @@ import (
 
 func ExtractVisibility(ctx *processing.ProcessingContext) processing.ProcessingResult {
 	prefix := ctx.Config.VisibilityPrefix
+	visibilities := make([]string, 0)
 
 	if prefix != "" {
-		visibilities := processing.ValuesByPrefix(ctx.File.Keywords(), prefix)
+		visibilities = processing.ValuesByPrefix(ctx.File.Keywords(), prefix)
+	}
 
+	if len(visibilities) == 0 && ctx.Config.UntaggedVisibility != "" {
+		ctx.Visibilty = ctx.Config.UntaggedVisibility
+		log.Infof("No visibility specified for %v, using %v", ctx.File.Name(), ctx.Visibilty)
+	} else if prefix != "" {
 		if len(visibilities) == 0 {
 			log.Infof("No visibility specified for %v, using default", ctx.File.Name())
 		} else {
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/jpg0/flickrdown/config"
+	"github.com/jpg0/flickrdown/flickraccess"
+	"github.com/jpg0/flickrup/processing"
+	"github.com/juju/errors"
+	"github.com/rickb777/date"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const VERIFY_REPORT_FILE = ".flickrdown-verify.json"
+const VERIFY_CONCURRENCY = 4
+
+const (
+	ISSUE_MISSING_LOCAL   = "missing_local"
+	ISSUE_MISSING_REMOTE  = "missing_remote"
+	ISSUE_UNMATCHED_LOCAL = "unmatched_local"
+	ISSUE_SIZE_MISMATCH   = "size_mismatch"
+	ISSUE_DATE_MISMATCH   = "date_mismatch"
+	ISSUE_CORRUPT         = "corrupt"
+)
+
+type VerifyIssue struct {
+	Kind    string `json:"kind"`
+	PhotoID string `json:"photo_id,omitempty"`
+	Path    string `json:"path,omitempty"`
+	Detail  string `json:"detail"`
+}
+
+type VerifyReport struct {
+	From         string        `json:"from"`
+	To           string        `json:"to"`
+	RemotePhotos int           `json:"remote_photos"`
+	LocalPhotos  int           `json:"local_photos"`
+	Issues       []VerifyIssue `json:"issues"`
+}
+
+//a downloaded photo, identified by its .meta file
+type LocalPhoto struct {
+	Base     string
+	MetaPath string
+	Meta     *flickraccess.Meta
+	Files    []string
+}
+
+func VerifyArchive(startAt date.Date, endAt date.Date, config *config.Config, reportFile string, checkSize bool) error {
+
+	ctx, err := buildContext(config)
+
+	if err != nil {
+		return errors.Annotate(err, "Failed to build context")
+	}
+
+	local, unmatched, err := ScanArchive(config.ArchiveDir)
+
+	if err != nil {
+		return errors.Annotate(err, "Failed to scan archive")
+	}
+
+	logrus.Infof("Found %v downloaded photos in %v", len(local), config.ArchiveDir)
+
+	report := &VerifyReport{
+		From:        startAt.Format(input_layout),
+		To:          endAt.Format(input_layout),
+		LocalPhotos: len(local),
+		Issues:      make([]VerifyIssue, 0),
+	}
+
+	remote := make([]interface{}, 0)
+	batch := ctx.source.Search(startAt, endAt)
+
+	for {
+		photo, err := batch.NextPhoto()
+
+		if err != nil {
+			return errors.Annotate(err, "Failed to load photo")
+		}
+
+		if photo == nil {
+			break
+		}
+
+		remote = append(remote, photo)
+	}
+
+	report.RemotePhotos = len(remote)
+
+	logrus.Infof("Found %v photos on Flickr from %v to %v", len(remote), report.From, report.To)
+
+	results := processing.ParallelMap(remote, func(p interface{}) interface{} {
+		return verifyPhoto(p.(flickraccess.RemotePhoto), local, ctx, checkSize)
+	}, VERIFY_CONCURRENCY)
+
+	seen := make(map[string]bool)
+
+	for i, r := range results {
+		seen[remote[i].(flickraccess.RemotePhoto).ID()] = true
+		report.Issues = append(report.Issues, r.([]VerifyIssue)...)
+	}
+
+	loc, err := config.Location()
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	for id, lp := range local {
+		if !seen[id] && uploadedWithin(lp.Meta, startAt.In(loc), endAt.In(loc)) {
+			report.Issues = append(report.Issues, VerifyIssue{ISSUE_MISSING_REMOTE, id, lp.MetaPath, "Photo no longer exists on Flickr"})
+		}
+	}
+
+	for _, path := range unmatched {
+		report.Issues = append(report.Issues, VerifyIssue{ISSUE_UNMATCHED_LOCAL, "", path, "No .meta file links this file to a Flickr photo"})
+	}
+
+	sort.Sort(byKindAndPath(report.Issues))
+
+	printReport(report)
+
+	return writeReport(report, reportFile)
+}
+
+func ScanArchive(archiveDir string) (map[string]*LocalPhoto, []string, error) {
+
+	byBase := make(map[string]*LocalPhoto)
+	sidecars := make(map[string]bool)
+	files := make([]string, 0)
+
+	err := filepath.Walk(archiveDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if strings.HasPrefix(info.Name(), ".") && path != archiveDir {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		if filepath.Ext(path) != ".meta" {
+			files = append(files, path)
+			return nil
+		}
+
+		meta, err := loadMeta(path)
+
+		if err != nil {
+			//upload sidecars share the extension but are not JSON
+			logrus.Debugf("Ignoring non-download meta file %v: %v", path, err)
+			sidecars[strings.TrimSuffix(path, ".meta")] = true
+			return nil
+		}
+
+		base := strings.TrimSuffix(path, ".meta")
+		byBase[base] = &LocalPhoto{
+			Base:     base,
+			MetaPath: path,
+			Meta:     meta,
+			Files:    make([]string, 0),
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+
+	unmatched := make([]string, 0)
+
+	for _, path := range files {
+		lp := byBase[strings.TrimSuffix(path, filepath.Ext(path))]
+
+		if lp != nil {
+			lp.Files = append(lp.Files, path)
+		} else if !sidecars[path] {
+			unmatched = append(unmatched, path)
+		}
+	}
+
+	byID := make(map[string]*LocalPhoto)
+
+	for _, lp := range byBase {
+		byID[lp.Meta.Id] = lp
+	}
+
+	return byID, unmatched, nil
+}
+
+func loadMeta(path string) (*flickraccess.Meta, error) {
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	meta := &flickraccess.Meta{}
+
+	err = json.Unmarshal(data, meta)
+
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if meta.Id == "" {
+		return nil, errors.Errorf("No photo ID in %v", path)
+	}
+
+	return meta, nil
+}
+
+func verifyPhoto(photo flickraccess.RemotePhoto, local map[string]*LocalPhoto, ctx *DownloadingContext, checkSize bool) []VerifyIssue {
+
+	issues := make([]VerifyIssue, 0)
+	id := photo.ID()
+
+	lp := local[id]
+
+	if lp == nil {
+		return append(issues, VerifyIssue{ISSUE_MISSING_LOCAL, id, "", "Photo has not been downloaded"})
+	}
+
+	meta, err := photo.GetMeta()
+
+	if err != nil {
+		logrus.Warnf("Failed to load metadata for %v: %v", id, err)
+		return issues
+	}
+
+	if meta.Dates.Taken != lp.Meta.Dates.Taken {
+		issues = append(issues, VerifyIssue{ISSUE_DATE_MISMATCH, id, lp.MetaPath, fmt.Sprintf("Taken %v locally, %v on Flickr", lp.Meta.Dates.Taken, meta.Dates.Taken)})
+	} else if meta.Dates.LastUpdate != lp.Meta.Dates.LastUpdate {
+		issues = append(issues, VerifyIssue{ISSUE_DATE_MISMATCH, id, lp.MetaPath, "Photo updated on Flickr since download"})
+	}
+
+	source, extension, err := originalSource(meta)
+
+	if err != nil {
+		logrus.Warnf("Cannot verify content of %v: %v", id, err)
+		return issues
+	}
+
+	path := lp.Base + "." + extension
+	info, err := os.Stat(path)
+
+	if err != nil {
+		return append(issues, VerifyIssue{ISSUE_MISSING_LOCAL, id, path, "Metadata present but file is missing"})
+	}
+
+	if info.Size() == 0 {
+		return append(issues, VerifyIssue{ISSUE_CORRUPT, id, path, "File is empty"})
+	}
+
+	if checkSize {
+		size, err := ctx.fetcher.Size(source)
+
+		if err != nil {
+			logrus.Warnf("Failed to check size of %v: %v", id, err)
+		} else if size >= 0 && size != info.Size() {
+			issues = append(issues, VerifyIssue{ISSUE_SIZE_MISMATCH, id, path, fmt.Sprintf("%v bytes locally, %v bytes on Flickr", info.Size(), size)})
+		}
+	}
+
+	err = checkIntegrity(path)
+
+	if err != nil {
+		issues = append(issues, VerifyIssue{ISSUE_CORRUPT, id, path, err.Error()})
+	}
+
+	return issues
+}
+
+//decodes images fully, so that truncated files are detected
+func checkIntegrity(path string) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jpg", ".jpeg", ".png", ".gif":
+	default:
+		return nil
+	}
+
+	file, err := os.Open(path)
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	defer file.Close()
+
+	_, _, err = image.Decode(file)
+
+	return err
+}
+
+func uploadedWithin(meta *flickraccess.Meta, startAt time.Time, endAt time.Time) bool {
+	posted, err := strconv.ParseInt(meta.Dates.Posted, 10, 64)
+
+	if err != nil {
+		return true
+	}
+
+	uploaded := time.Unix(posted, 0)
+
+	return !uploaded.Before(startAt) && uploaded.Before(endAt)
+}
+
+func printReport(report *VerifyReport) {
+	counts := make(map[string]int)
+
+	for _, issue := range report.Issues {
+		counts[issue.Kind]++
+		fmt.Printf("%-16v %-12v %v: %v\n", issue.Kind, issue.PhotoID, issue.Path, issue.Detail)
+	}
+
+	fmt.Printf("\nVerified %v remote and %v local photos from %v to %v\n", report.RemotePhotos, report.LocalPhotos, report.From, report.To)
+
+	for _, kind := range []string{ISSUE_MISSING_LOCAL, ISSUE_MISSING_REMOTE, ISSUE_UNMATCHED_LOCAL, ISSUE_SIZE_MISMATCH, ISSUE_DATE_MISMATCH, ISSUE_CORRUPT} {
+		fmt.Printf("  %-16v %v\n", kind, counts[kind])
+	}
+}
+
+func writeReport(report *VerifyReport, reportFile string) error {
+	asJson, err := json.MarshalIndent(report, "", "  ")
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	err = ioutil.WriteFile(reportFile, asJson, 0644)
+
+	if err != nil {
+		return errors.Annotatef(err, "Failed to write report to %v", reportFile)
+	}
+
+	logrus.Infof("Wrote report to %v", reportFile)
+
+	return nil
+}
+
+type byKindAndPath []VerifyIssue
+
+func (a byKindAndPath) Len() int {
+	return len(a)
+}
+func (a byKindAndPath) Swap(i, j int) {
+	a[i], a[j] = a[j], a[i]
+}
+func (a byKindAndPath) Less(i, j int) bool {
+	if a[i].Kind != a[j].Kind {
+		return a[i].Kind < a[j].Kind
+	}
+	return a[i].Path < a[j].Path
+}
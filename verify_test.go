@@ -0,0 +1,105 @@
+package main
+
+import (
+	"github.com/golang/mock/gomock"
+	"github.com/jpg0/flickrdown/mocks"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func writeArchive(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "flickrdown")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range files {
+		err = ioutil.WriteFile(dir + "/" + name, []byte(content), 0644)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func TestScanArchive(t *testing.T) {
+	dir := writeArchive(t, map[string]string{
+		"beach.meta":     testMeta,
+		"beach.png":      "content",
+		"movie.mov":      "content",
+		"movie.mov.meta": "keywords: a,b",
+		"stray.jpg":      "content",
+	})
+	defer os.RemoveAll(dir)
+
+	local, unmatched, err := ScanArchive(dir)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(local) != 1 || local["123"] == nil {
+		t.Fatalf("Expected photo 123 to be found, got %v", local)
+	}
+
+	if len(local["123"].Files) != 1 {
+		t.Errorf("Expected one file for photo 123, got %v", local["123"].Files)
+	}
+
+	if len(unmatched) != 1 || unmatched[0] != dir + "/stray.jpg" {
+		t.Errorf("Expected only stray.jpg to be unmatched, got %v", unmatched)
+	}
+}
+
+func TestVerifyPhotoDetectsCorruptAndSizeMismatch(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	dir := writeArchive(t, map[string]string{
+		"beach.meta": testMeta,
+		"beach.png":  "not a png",
+	})
+	defer os.RemoveAll(dir)
+
+	local, _, err := ScanArchive(dir)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	photo := mock_flickr.NewMockRemotePhoto(mockCtrl)
+	fetcher := mock_flickr.NewMockContentFetcher(mockCtrl)
+
+	photo.EXPECT().ID().Return("123").AnyTimes()
+	photo.EXPECT().GetMeta().Return(newTestMeta(t), nil)
+	fetcher.EXPECT().Size("https://farm1.staticflickr.com/1/123_abc_o.png").Return(int64(100), nil)
+
+	issues := verifyPhoto(photo, local, &DownloadingContext{fetcher: fetcher}, true)
+
+	if len(issues) != 2 {
+		t.Fatalf("Expected 2 issues, got %v", issues)
+	}
+
+	assertEquals(ISSUE_SIZE_MISMATCH, issues[0].Kind, t)
+	assertEquals(ISSUE_CORRUPT, issues[1].Kind, t)
+}
+
+func TestVerifyPhotoMissingLocally(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	photo := mock_flickr.NewMockRemotePhoto(mockCtrl)
+	photo.EXPECT().ID().Return("456").AnyTimes()
+
+	issues := verifyPhoto(photo, map[string]*LocalPhoto{}, &DownloadingContext{}, true)
+
+	if len(issues) != 1 {
+		t.Fatalf("Expected 1 issue, got %v", issues)
+	}
+
+	assertEquals(ISSUE_MISSING_LOCAL, issues[0].Kind, t)
+}